@@ -0,0 +1,151 @@
+package andrew
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// DefaultAcmeDirectory is Let's Encrypt's production directory.
+	DefaultAcmeDirectory = acme.LetsEncryptURL
+	// DefaultAcmeHTTPAddress is where the HTTP-01 challenge has to be answered: port 80.
+	DefaultAcmeHTTPAddress = ":80"
+)
+
+// AcmeInfo is how Andrew gets certificates for itself from a certificate authority that
+// speaks ACME, like Let's Encrypt. It gets one for the host in the server's BaseUrl the
+// first time it's asked for it, keeps it in CacheDir so a restart doesn't ask for another,
+// and renews it before it expires.
+type AcmeInfo struct {
+	Email        string // Who the certificate authority writes to about problems with the certificates. Optional.
+	CacheDir     string // Where the account key and certificates are kept between runs.
+	DirectoryURL string // The ACME directory of the certificate authority.
+	CACertPath   string // A PEM file of the roots to trust when talking to the certificate authority, for a test one like Pebble. Empty means the system's roots.
+	HTTPAddress  string // Where to answer HTTP-01 challenges, unless the server has its own HTTPAddress. Everything else asked for there is redirected to https.
+}
+
+// defaultAcmeCacheDir keeps certificates in the user's cache directory, well away from the
+// content root, where they'd be served.
+func defaultAcmeCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(".", ".andrew-acme")
+	}
+
+	return filepath.Join(cacheDir, "andrew", "acme")
+}
+
+// acmeHost is the host certificates are requested for: the one in baseUrl, which has to be
+// an https URL, as that's where the site says it's served.
+func acmeHost(baseUrl string) (string, error) {
+	parsed, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", fmt.Errorf("--acme needs a baseUrl it can read: %w", err)
+	}
+
+	if parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return "", fmt.Errorf("--acme needs an https:// baseUrl with a hostname to get a certificate for, not %q", baseUrl)
+	}
+
+	return parsed.Hostname(), nil
+}
+
+// newAcmeManager builds the autocert.Manager that gets and renews certificates for hosts,
+// and for nothing else: anyone can point a name at the server, and the certificate
+// authority's rate limits shouldn't be spent on them.
+func newAcmeManager(info AcmeInfo, hosts ...string) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: info.DirectoryURL}
+
+	if info.CACertPath != "" {
+		pem, err := os.ReadFile(info.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading --acme-ca-cert: %w", err)
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("--acme-ca-cert has no PEM certificates in it: " + info.CACertPath)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(info.CacheDir),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      info.Email,
+		Client:     client,
+	}, nil
+}
+
+// ListenAndServeAcme serves https at the server's Address with certificates from the ACME
+// certificate authority in info. The TLS-ALPN-01 challenge is answered there, and the
+// HTTP-01 challenge at the server's HTTPAddress, or info.HTTPAddress if it hasn't got one,
+// so either can be used to prove the host is ours.
+func (a *Server) ListenAndServeAcme(info AcmeInfo) error {
+	a.shared().redirects.start(a.SiteFiles, a.partialDepth())
+
+	host, err := acmeHost(a.BaseUrl)
+	if err != nil {
+		return err
+	}
+
+	manager, err := newAcmeManager(info, host)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.GetCertificate = logCertificateErrors(tlsConfig.GetCertificate)
+	a.HTTPServer.TLSConfig = tlsConfig
+
+	if err := a.configureTLS(tlsConfig); err != nil {
+		return err
+	}
+
+	listener, err := listen(a.Address, a.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	// Failing to listen for http isn't fatal: TLS-ALPN-01 can still get certificates.
+	httpAddress := a.HTTPAddress
+	if httpAddress == "" {
+		httpAddress = info.HTTPAddress
+	}
+
+	if httpAddress != "" {
+		a.listenForHTTP(httpAddress, manager.HTTPHandler(a.httpsRedirect()))
+	}
+
+	slog.Info("getting certificates with ACME", "host", host, "directory", info.DirectoryURL, "cache", info.CacheDir)
+
+	return a.HTTPServer.ServeTLS(listener, "", "")
+}
+
+// logCertificateErrors logs why a certificate couldn't be had, which otherwise only the
+// client that asked for it would see, as a failed handshake.
+func logCertificateErrors(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Warn("no certificate for handshake", "serverName", hello.ServerName, "error", err)
+		}
+
+		return cert, err
+	}
+}
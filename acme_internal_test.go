@@ -0,0 +1,96 @@
+package andrew
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcmeHostIsTheBaseUrlsHost(t *testing.T) {
+	t.Parallel()
+
+	host, err := acmeHost("https://www.example.com:8443/blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host != "www.example.com" {
+		t.Errorf("expected www.example.com, received %q", host)
+	}
+
+	for _, baseUrl := range []string{"http://www.example.com", "https://", "www.example.com"} {
+		if _, err := acmeHost(baseUrl); err == nil {
+			t.Errorf("%s: expected an error", baseUrl)
+		}
+	}
+}
+
+func TestAcmeManagerOnlyGetsCertificatesForTheSitesHost(t *testing.T) {
+	t.Parallel()
+
+	cacheDir := t.TempDir()
+	manager, err := newAcmeManager(AcmeInfo{CacheDir: cacheDir, DirectoryURL: "https://localhost:14000/dir"}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.HostPolicy(context.Background(), "www.example.com"); err != nil {
+		t.Errorf("expected www.example.com to be allowed, received %s", err)
+	}
+
+	if err := manager.HostPolicy(context.Background(), "attacker.example.net"); err == nil {
+		t.Error("expected attacker.example.net not to be allowed")
+	}
+
+	if manager.Client.DirectoryURL != "https://localhost:14000/dir" {
+		t.Errorf("expected the directory to be https://localhost:14000/dir, received %q", manager.Client.DirectoryURL)
+	}
+
+	if err := manager.Cache.Put(context.Background(), "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.Cache.Get(context.Background(), "key"); err != nil {
+		t.Errorf("expected the cache to be kept in %s: %s", cacheDir, err)
+	}
+}
+
+func TestAcmeManagerTrustsTheCACertItIsGiven(t *testing.T) {
+	t.Parallel()
+
+	manager, err := newAcmeManager(AcmeInfo{CacheDir: t.TempDir(), CACertPath: "testdata/acme-ca.pem"}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manager.Client.HTTPClient == nil {
+		t.Error("expected a client that trusts testdata/acme-ca.pem")
+	}
+
+	_, err = newAcmeManager(AcmeInfo{CacheDir: t.TempDir(), CACertPath: "acme.go"}, "www.example.com")
+	if err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("expected an error about a file without certificates, received %v", err)
+	}
+}
+
+func TestAcmeHTTPHandlerRedirectsToHttps(t *testing.T) {
+	t.Parallel()
+
+	manager, err := newAcmeManager(AcmeInfo{CacheDir: t.TempDir()}, "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	manager.HTTPHandler(nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://www.example.com/blog/?page=2", nil))
+
+	if recorder.Code != http.StatusFound {
+		t.Errorf("expected status %d, received %d", http.StatusFound, recorder.Code)
+	}
+
+	if location := recorder.Header().Get("Location"); location != "https://www.example.com/blog/?page=2" {
+		t.Errorf("expected a redirect to https://www.example.com/blog/?page=2, received %q", location)
+	}
+}
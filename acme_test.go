@@ -0,0 +1,77 @@
+package andrew_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+// TestAcmeGetsACertificateFromPebble gets a certificate from Pebble, Let's Encrypt's test
+// certificate authority. It's skipped unless one is running, e.g. with
+//
+//	pebble -config test/config/pebble-config.json
+//	ANDREW_TEST_ACME_DIRECTORY=https://localhost:14000/dir ANDREW_TEST_ACME_CA=test/certs/pebble.minica.pem go test -run Pebble
+//
+// Pebble checks challenges on ports 5001 (TLS-ALPN-01) and 5002 (HTTP-01), so Andrew
+// listens on those.
+func TestAcmeGetsACertificateFromPebble(t *testing.T) {
+	directory, caCert := os.Getenv("ANDREW_TEST_ACME_DIRECTORY"), os.Getenv("ANDREW_TEST_ACME_CA")
+	if directory == "" || caCert == "" {
+		t.Skip("set ANDREW_TEST_ACME_DIRECTORY and ANDREW_TEST_ACME_CA to test against a running Pebble")
+	}
+
+	s := andrew.NewServer(fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, "localhost:5001", "https://localhost:5001", andrew.RssInfo{})
+	t.Cleanup(func() { s.Close() })
+
+	served := make(chan error, 1)
+	go func() {
+		served <- andrew.ListenAndServe(s, &andrew.CertInfo{Acme: &andrew.AcmeInfo{
+			CacheDir:     t.TempDir(),
+			DirectoryURL: directory,
+			CACertPath:   caCert,
+			HTTPAddress:  ":5002",
+		}})
+	}()
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+		// Pebble's certificates chain to a root it makes up each run.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	var resp *http.Response
+	var err error
+	for range 20 {
+		resp, err = client.Get(s.BaseUrl + "/")
+		if err == nil {
+			break
+		}
+
+		select {
+		case serveErr := <-served:
+			if !errors.Is(serveErr, http.ErrServerClosed) {
+				t.Fatal(serveErr)
+			}
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, received %d", http.StatusOK, resp.StatusCode)
+	}
+
+	issuer := resp.TLS.PeerCertificates[0].Issuer.String()
+	if !strings.Contains(issuer, "Pebble") {
+		t.Errorf("expected a certificate issued by Pebble, received one issued by %s", issuer)
+	}
+}
@@ -1,19 +1,28 @@
 package andrew
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 // CertInfo tracks SSL certificate information. Andrew can optionally serve HTTPS traffic,
-// but to do so it has to know how to find both the path to the certificate and to the private key.
+// but to do so it has to know how to find both the path to the certificate and to the private key,
+// or, with Acme, how to get certificates for itself.
 type CertInfo struct {
 	CertPath       string
 	PrivateKeyPath string
+	Acme           *AcmeInfo // Get certificates from an ACME certificate authority, rather than from CertPath and PrivateKeyPath.
 }
 
 type RssInfo struct {
@@ -22,6 +31,75 @@ type RssInfo struct {
 	Dir         string
 }
 
+// RobotsInfo is what a generated robots.txt needs to know beyond what the pages say about
+// themselves.
+type RobotsInfo struct {
+	DisallowPaths   []string // Paths every crawler is asked to stay out of, like /private/.
+	BlockedCrawlers []string // User-agents asked to stay out of the whole site.
+}
+
+// SecurityInfo is how Andrew hardens its responses for a site served without a proxy in
+// front of it to do that.
+type SecurityInfo struct {
+	Headers       bool   // Send X-Content-Type-Options, Referrer-Policy, X-Frame-Options and the CSP.
+	CSP           string // The Content-Security-Policy. Empty means none is sent.
+	CSPReportOnly bool   // Send the CSP as Content-Security-Policy-Report-Only, so it reports what it would block without blocking it.
+	HSTS          string // The Strict-Transport-Security sent with https responses. Empty means none is sent.
+}
+
+// TLSInfo is how Andrew's https is set up, beyond its certificate, and which parts of the
+// site need a client certificate to be read.
+type TLSInfo struct {
+	MinVersion      uint16        // The oldest TLS version accepted, like tls.VersionTLS12. 0 means DefaultTLSMinVersion.
+	CipherSuites    []uint16      // The TLS 1.2 cipher suites allowed, in order of preference. Empty means Go's defaults.
+	Curves          []tls.CurveID // The key exchanges allowed, in order of preference. Empty means Go's defaults.
+	ClientCAPath    string        // A PEM file of the CAs client certificates must be signed by.
+	ClientCertPaths []string      // Paths, like /drafts/, that can only be read with a client certificate signed by one of ClientCAPath's CAs.
+}
+
+// Options holds everything the end user can set with a command-line option, grouped by
+// the part of Andrew it configures. CertInfo is nil when Andrew should serve http.
+type Options struct {
+	CertInfo        *CertInfo
+	RssInfo         *RssInfo
+	RobotsInfo      *RobotsInfo
+	SecurityInfo    *SecurityInfo
+	TLSInfo         *TLSInfo
+	MaxPartialDepth int  // How deeply partials can be nested inside partials.
+	DevMode         bool // Show the details of template mistakes in the browser.
+	ServeDotfiles   bool // Serve files under dotted paths like .git.
+	ServePartials   bool // Serve partial and layout files.
+	SymlinkPolicy   SymlinkPolicy
+	HTTPAddress     string      // When serving https, where to listen for plain http and redirect it to https.
+	HTTPHealthCheck bool        // Answer /healthz on HTTPAddress rather than redirecting it.
+	SitesPath       string      // Serve the sites in this config file, rather than one site.
+	SocketMode      fs.FileMode // The permissions of the unix sockets Andrew listens on.
+}
+
+// DefaultAICrawlers are the user-agents of crawlers that gather training data for AI models.
+// --block-ai-crawlers asks all of them to stay out of your site. Like everything in a
+// robots.txt, this is a request; well-behaved crawlers honour it.
+var DefaultAICrawlers = []string{
+	"GPTBot",
+	"ChatGPT-User",
+	"OAI-SearchBot",
+	"ClaudeBot",
+	"anthropic-ai",
+	"Claude-Web",
+	"CCBot",
+	"Google-Extended",
+	"Applebot-Extended",
+	"PerplexityBot",
+	"Bytespider",
+	"Amazonbot",
+	"Meta-ExternalAgent",
+	"FacebookBot",
+	"cohere-ai",
+	"Diffbot",
+	"omgili",
+	"Timpibot",
+}
+
 const (
 	DefaultContentRoot        = "."
 	DefaultRssRoot            = "."
@@ -29,6 +107,15 @@ const (
 	DefaultBaseUrl            = "http://localhost:8080"
 	DefaultRssFeedTitle       = "Home"
 	DefaultRssFeedDescription = "Writings"
+
+	// DefaultContentSecurityPolicy only allows what comes from the site itself, plus data:
+	// images. Inline <script> and <style> blocks are allowed by their hashes, which Andrew
+	// adds to the policy for each page.
+	DefaultContentSecurityPolicy = "default-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'self'"
+
+	// DefaultHSTS tells browsers to only ever use https for the site for the next two years.
+	// Its subdomains are left alone: they may well not be Andrew's to promise https for.
+	DefaultHSTS = "max-age=63072000"
 )
 
 func init() {
@@ -40,44 +127,150 @@ func init() {
 }
 
 // Main is the implementation of main. It's here to get main's logic into a testable package.
+// A mistake on the command line, or a server that can't start, is written to printDest and
+// exits with 1: the end user needs to read what went wrong, not a stack trace.
 func Main(args []string, printDest io.Writer) int {
-	certInfo, rssInfo, remainingArgs, err := ParseOpts(args, printDest)
+	opts, remainingArgs, err := ParseOptions(args, printDest)
 	if err != nil {
 		// If we display a -h or --help flag, we helped the user and it's time to exit.
 		if err.Error() == "helped" {
 			return 0
 		}
-		panic(err)
+		return exitWithError(printDest, err)
+	}
+
+	if opts.SitesPath != "" {
+		return serveSites(opts, remainingArgs, printDest)
 	}
 
 	contentRoot, address, baseUrl := ParseArgs(remainingArgs)
 	contentRoot, err = filepath.Abs(contentRoot)
 
 	if err != nil {
-		panic(err)
+		return exitWithError(printDest, err)
+	}
+
+	andrewServer, err := newServerFromOptions(contentRoot, address, baseUrl, *opts.RssInfo, opts)
+	if err != nil {
+		return exitWithError(printDest, err)
 	}
 
-	siteFiles := os.DirFS(contentRoot)
+	fmt.Fprintf(printDest, "Serving from %s, listening on %s, serving on %s", contentRoot, address, baseUrl)
 
-	// The rss dir arrives as the end user typed it. Main is the first place that knows both
-	// the content root and the site's fs.FS, so it is the first place that can resolve it.
-	rssInfo.Dir, err = resolveRssDir(siteFiles, rssInfo.Dir, contentRoot)
+	stop, stopListening := stopSignals()
+	defer stopListening()
+
+	err = serveUntilStopped(stop, func() error { return ListenAndServe(andrewServer, opts.CertInfo) }, andrewServer.Shutdown)
 	if err != nil {
-		panic(err)
+		return exitWithError(printDest, err)
 	}
 
-	andrewServer := NewServer(siteFiles, address, baseUrl, *rssInfo)
+	return 0
+}
 
-	fmt.Fprintf(printDest, "Serving from %s, listening on %s, serving on %s", contentRoot, address, baseUrl)
+// serveSites is Main for --sites: several sites served together, at the address that's the
+// only argument.
+func serveSites(opts *Options, remainingArgs []string, printDest io.Writer) int {
+	if len(remainingArgs) > 1 {
+		return exitWithError(printDest, errors.New("--sites takes the address to listen on as its only argument; the sites' content roots and base urls are in its config"))
+	}
+
+	address := DefaultAddress
+	if len(remainingArgs) == 1 {
+		address = remainingArgs[0]
+	}
+
+	config, err := LoadSitesConfig(opts.SitesPath)
+	if err != nil {
+		return exitWithError(printDest, err)
+	}
 
-	err = ListenAndServe(andrewServer, certInfo)
+	sites, err := NewVirtualHosts(config, address, opts)
 	if err != nil {
-		panic(err)
+		return exitWithError(printDest, err)
+	}
+
+	fmt.Fprintf(printDest, "Serving %d sites from %s, listening on %s", len(config.Sites), opts.SitesPath, address)
+
+	stop, stopListening := stopSignals()
+	defer stopListening()
+
+	if err := serveUntilStopped(stop, func() error { return sites.ListenAndServe(opts.CertInfo) }, sites.Shutdown); err != nil {
+		return exitWithError(printDest, err)
 	}
 
 	return 0
 }
 
+// newServerFromOptions is a Server for the site in contentRoot, set up as the options say.
+func newServerFromOptions(contentRoot string, address string, baseUrl string, rssInfo RssInfo, opts *Options) (*Server, error) {
+	contentRoot, err := filepath.Abs(contentRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	siteFiles := NewSiteFS(contentRoot, opts.SymlinkPolicy)
+
+	// The rss dir arrives as the end user typed it. This is the first place that knows both
+	// the content root and the site's fs.FS, so it is the first place that can resolve it.
+	rssInfo.Dir, err = resolveRssDir(siteFiles, rssInfo.Dir, contentRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	andrewServer := NewServer(siteFiles, address, baseUrl, rssInfo)
+	andrewServer.RobotsInfo = *opts.RobotsInfo
+	andrewServer.DevMode = opts.DevMode
+	andrewServer.SecurityInfo = *opts.SecurityInfo
+	andrewServer.ServeDotfiles = opts.ServeDotfiles
+	andrewServer.ServePartials = opts.ServePartials
+	andrewServer.TLSInfo = *opts.TLSInfo
+	andrewServer.HTTPAddress = opts.HTTPAddress
+	andrewServer.HTTPHealthCheck = opts.HTTPHealthCheck
+	andrewServer.SocketMode = opts.SocketMode
+	andrewServer.MaxPartialDepth = opts.MaxPartialDepth
+
+	return andrewServer, nil
+}
+
+// shutdownTimeout is how long the requests in flight get to finish when Andrew is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// stopSignals are the signals that ask Andrew to stop: SIGTERM, from systemd or docker, and
+// SIGINT, from ctrl-c. The func stops listening for them.
+func stopSignals() (<-chan os.Signal, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	return signals, func() { signal.Stop(signals) }
+}
+
+// serveUntilStopped serves until serve gives up, or until something arrives on stop. Then
+// shutdown stops listening, and gives the requests in flight up to shutdownTimeout to
+// finish, so restarting Andrew doesn't cut anybody off halfway through a page.
+func serveUntilStopped(stop <-chan os.Signal, serve func() error, shutdown func(context.Context) error) error {
+	served := make(chan error, 1)
+	go func() { served <- serve() }()
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-stop:
+		slog.Info("shutting down", "signal", sig.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		return shutdown(ctx)
+	}
+}
+
+// exitWithError tells the end user what went wrong, and returns the exit code for it.
+func exitWithError(printDest io.Writer, err error) int {
+	fmt.Fprintf(printDest, "andrew: %s\n", err)
+	return 1
+}
+
 // ListenAndServe creates a server in the contentRoot, listening at the address, with links on autogenerated
 // pages to the baseUrl.
 // contentRoot - an fs.FS at some location, whether that's a virtual fs.FS such as an fs.Testfs or an
@@ -85,13 +278,17 @@ func Main(args []string, printDest io.Writer) int {
 //	fs.FS at a location on your file system such as os.DirFS.
 //
 // contentRoot - an initialised fs.FS. Some implementation details sometimes differ amongst different fs.FS;
-// Andrew internally uses an os.DirFS and tests with an fstest.MapFS, so those two have some code examples herein.
+// Andrew internally uses NewSiteFS, an os.DirFS with a policy for symbolic links, and tests with an fstest.MapFS, so those two have some code examples herein.
 // address - an ip:port combination. The AndrewServer will bind an http server here.
 // hostname - your hostname! This is injected into your sitemap and RSS feeds.
-// certInfo - certificate info type. If the members are empty, Andrew serves http.
+// certInfo - certificate info type. If the members are empty, Andrew serves http. If Acme is set, Andrew gets its own certificates.
 // rssInfo - an RSS info structure. This lets the RSS Feed show info and description.
 func ListenAndServe(andrew *Server, certInfo *CertInfo) error {
 
+	if certInfo != nil && certInfo.Acme != nil {
+		return andrew.ListenAndServeAcme(*certInfo.Acme)
+	}
+
 	if certInfo != nil && certInfo.CertPath != "" && certInfo.PrivateKeyPath != "" {
 		slog.Debug("ListenAndServe", "certPath", certInfo.CertPath)
 		slog.Debug("ListenAndServe", "certPrivateKeyPath", certInfo.PrivateKeyPath)
@@ -106,7 +303,22 @@ func ListenAndServe(andrew *Server, certInfo *CertInfo) error {
 	return err
 }
 
-// ParseOpts parses command-line options and returns a CertInfo struct,
+// ParseOpts parses command-line options and returns a CertInfo, an RssInfo,
+// remaining arguments, and an error if any.
+//
+// It's what ParseOptions was before there were more options than certificates and rss
+// settings, and it's kept for the programs that already call it. The options it doesn't
+// return are parsed, and checked, all the same.
+func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []string, error) {
+	opts, remainingArgs, err := ParseOptions(args, printDest)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return opts.CertInfo, opts.RssInfo, remainingArgs, nil
+}
+
+// ParseOptions parses command-line options and returns an Options struct,
 // remaining arguments, and an error if any.
 //
 // The args parameter contains the command-line arguments, and printDest
@@ -114,9 +326,9 @@ func ListenAndServe(andrew *Server, certInfo *CertInfo) error {
 //
 // Supported options are documented in the help message.
 //
-// Returns a CertInfo struct containing the SSL certificate and key paths, an RssInfo struct with info and description if provided,
-// the remaining arguments, and any error encountered.
-func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []string, error) {
+// Returns an Options struct containing the SSL certificate and key paths, the RssInfo with info and description if provided,
+// and the RobotsInfo for robots.txt, the remaining arguments, and any error encountered.
+func ParseOptions(args []string, printDest io.Writer) (*Options, []string, error) {
 	// Whitespace formatting here provided lovingly by eyeballing it.
 	help := `Usage: Andrew runs from a directory we call the Content Root. By default it's the present working directory that andrew runs in,
 	but you can specify as your first argument a different directory.
@@ -126,12 +338,14 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 	andrew supports both arguments and options. Arguments are positional and mandatory; options are introduced with a flag and are not mandatory.
 	The options are stripped from the command line before the arguments are parsed, so order of options and arguments is irrelevant.
 	
-	andrew [contentRoot] [address] [baseUrl] || (-c|--cert) path/to/ssl.crt (-p|--privatekey) path/to/ssl.key (-h|--help) help message
+	andrew [contentRoot] [address] [baseUrl] || (-c|--cert) path/to/ssl.crt (-p|--privatekey) path/to/ssl.key || --acme (-h|--help) help message
 	
 	Arguments:
 	  contentRoot          The root directory of your content. Defaults to '.' if not specified.
 	  address              The address to bind to. Defaults to 'localhost:8080' if not specified.
 				If in doubt, you probably want 0.0.0.0:<something>
+				unix:/path/to/andrew.sock listens on a unix socket, and systemd on a socket passed in by
+				systemd socket activation; systemd:name picks the one with that FileDescriptorName.
 	  baseUrl              The protocol://hostname for your server. Defaults to 'http://localhost:8080' 
 				if not specified. Used to generate sitemap/rss feed accurately.
 	
@@ -140,9 +354,55 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 				is signed by a certificate authority, the certFile should be the concatenation of 
 				the server's certificate, any intermediates, and the CA's certificate.
 	  -p, --privatekey     Path to the private key file. Must be used with --cert.
+	  --acme               Get certificates for the baseUrl's host from Let's Encrypt, or another ACME certificate authority,
+				and renew them before they expire. The baseUrl must be https://. Can't be used with --cert.
+	  --acme-email         An email address the certificate authority can write to about your certificates.
+	  --acme-cache         Where to keep the certificates between runs. Defaults to andrew/acme in your user cache directory.
+	  --acme-directory     The ACME directory of the certificate authority. Defaults to Let's Encrypt's.
+	  --acme-ca-cert       A PEM file of the roots to trust when talking to the certificate authority, for a test one like Pebble.
+	  --acme-http-address  Where to answer HTTP-01 challenges, redirecting everything else to https. Defaults to :80.
+				--http-address takes its place when it's given.
+	  --http-address       When serving https, also listen for plain http here, and redirect it to the same path on https.
+				ACME's HTTP-01 challenges are answered here too.
+	  --http-health-check  Answer health checks at /healthz on the --http-address, rather than redirecting them.
+	  --tls-min-version    The oldest TLS version to accept: 1.2 or 1.3. Defaults to 1.2.
+	  --tls-ciphers        The TLS 1.2 cipher suites to allow, comma separated, in order of preference, like
+				TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256. Defaults to Go's choice.
+	  --tls-curves         The key exchanges to allow, comma separated from X25519, P-256, P-384 and P-521, in order of
+				preference. Defaults to Go's choice.
+	  --client-ca          A PEM file of the CAs that sign client certificates. Needed by --client-cert-path.
+	  --client-cert-path   A path, like /drafts/, that can only be read with a client certificate signed by a --client-ca.
+				Can be given more than once. Pages under it are left out of listings on other pages, the sitemap
+				and the rss feed.
+	  --hsts               The Strict-Transport-Security to send with https responses, or "none" for none. Defaults to
+				"max-age=63072000" when security headers are on.
 	  -t, --rsstitle       The title of your rss feed. Be zany.
 	  -d, --rssdescription The description of your rss feed. Go wild. Wrap it in quotes.
 	  -r, --rssdir         The directory you would like your rss feed to serve. By default, all html pages discovered are part of the rss feed.
+	  --robots-disallow    A path to ask every crawler to stay out of in the generated robots.txt. Can be given more than once.
+	  --block-crawler      A crawler's user-agent to ask to stay out of the whole site in the generated robots.txt.
+				Can be given more than once.
+	  --block-ai-crawlers  Ask the crawlers that gather AI training data to stay out of the whole site in the generated robots.txt.
+	  --max-partial-depth  How deeply partials can be nested inside other partials. Defaults to 10.
+	  --security-headers   Send security headers even when serving http. They're on by default when serving https.
+	  --no-security-headers Don't send security headers, even when serving https.
+	  --csp                The Content-Security-Policy to send, "default" for Andrew's own, or "none" for no policy. None is
+				sent unless it's given. Andrew adds hashes for each page's inline <script> and <style> blocks to it.
+				Implies --security-headers.
+	  --csp-report-only    Send the Content-Security-Policy as report-only, reporting what it would block to /csp-report,
+				which logs a summary, without blocking anything. Uses Andrew's own policy unless --csp gives one.
+				Implies --security-headers.
+	  --symlinks           What to do about symbolic links in the content root: follow, within-root-only or deny.
+				Defaults to within-root-only, which follows the links that end up inside the content root.
+	  --serve-dotfiles     Serve files under dotted paths, like .git/config or .env. They're answered with a 404 by default,
+				apart from .well-known.
+	  --serve-partials     Serve partial and layout files. They're answered with a 404 by default.
+	  --socket-mode        The permissions of the unix sockets Andrew listens on, in octal. Defaults to 0660, so the
+				socket's owner and group can connect.
+	  --sites              Serve several sites, each answering to its own hostnames, from a JSON config of their content
+				roots, base urls and rss settings. The address to listen on is then the only argument.
+	  --dev                Development mode. A page with a mistake in its templates shows what and where the mistake is,
+				rather than a plain 500 error.
 	  -h, --help           Display this help message.
 	
 	Environment:
@@ -150,7 +410,22 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 `
 
 	var certPath, keyPath string
+	useAcme := false
+	acmeInfo := &AcmeInfo{CacheDir: defaultAcmeCacheDir(), DirectoryURL: DefaultAcmeDirectory, HTTPAddress: DefaultAcmeHTTPAddress}
 	rssInfo := &RssInfo{Title: DefaultRssFeedTitle, Description: DefaultRssFeedDescription, Dir: DefaultRssRoot}
+	robotsInfo := &RobotsInfo{}
+	partialDepth := DefaultMaxPartialDepth
+	devMode := false
+	serveDotfiles, servePartials := false, false
+	symlinkPolicy := DefaultSymlinkPolicy
+	securityInfo := &SecurityInfo{}
+	securityHeaders, noSecurityHeaders := false, false
+	cspGiven := false
+	hsts, hstsGiven := "", false
+	httpAddress, httpHealthCheck := "", false
+	tlsInfo := &TLSInfo{MinVersion: DefaultTLSMinVersion}
+	sitesPath := ""
+	socketMode := DefaultSocketMode
 
 	remainingArgs := []string{}
 
@@ -164,11 +439,37 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 
 				// Check if certPath is a valid file
 				if err := checkFileExists(certPath); err != nil {
-					return nil, nil, nil, fmt.Errorf("certificate %w", err)
+					return nil, nil, fmt.Errorf("certificate %w", err)
 				}
 			} else {
-				return nil, nil, nil, errors.New("missing certificate path after " + arg)
+				return nil, nil, errors.New("missing certificate path after " + arg)
+			}
+
+		case "--acme":
+			useAcme = true
+
+		case "--acme-email", "--acme-cache", "--acme-directory", "--acme-ca-cert", "--acme-http-address":
+			if i+1 >= len(args) {
+				return nil, nil, errors.New("missing value after " + arg)
+			}
+
+			switch arg {
+			case "--acme-email":
+				acmeInfo.Email = args[i+1]
+			case "--acme-cache":
+				acmeInfo.CacheDir = args[i+1]
+			case "--acme-directory":
+				acmeInfo.DirectoryURL = args[i+1]
+			case "--acme-ca-cert":
+				if err := checkFileExists(args[i+1]); err != nil {
+					return nil, nil, fmt.Errorf("acme ca certificate %w", err)
+				}
+				acmeInfo.CACertPath = args[i+1]
+			case "--acme-http-address":
+				acmeInfo.HTTPAddress = args[i+1]
 			}
+			useAcme = true
+			i++
 
 		case "-d", "--rssdescription":
 			if i+1 < len(args) {
@@ -181,9 +482,158 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 				rssInfo.Dir = args[i+1]
 				i++
 			} else {
-				return nil, nil, nil, errors.New("missing rss directory after " + arg)
+				return nil, nil, errors.New("missing rss directory after " + arg)
+			}
+
+		case "--robots-disallow":
+			if i+1 < len(args) {
+				robotsInfo.DisallowPaths = append(robotsInfo.DisallowPaths, args[i+1])
+				i++
+			} else {
+				return nil, nil, errors.New("missing path after " + arg)
 			}
 
+		case "--block-crawler":
+			if i+1 < len(args) {
+				robotsInfo.BlockedCrawlers = append(robotsInfo.BlockedCrawlers, args[i+1])
+				i++
+			} else {
+				return nil, nil, errors.New("missing user-agent after " + arg)
+			}
+
+		case "--block-ai-crawlers":
+			robotsInfo.BlockedCrawlers = append(robotsInfo.BlockedCrawlers, DefaultAICrawlers...)
+
+		case "--max-partial-depth":
+			if i+1 < len(args) {
+				depth, err := strconv.Atoi(args[i+1])
+				if err != nil || depth < 1 {
+					return nil, nil, errors.New("--max-partial-depth must be a whole number of at least 1, not " + args[i+1])
+				}
+				partialDepth = depth
+				i++
+			} else {
+				return nil, nil, errors.New("missing depth after " + arg)
+			}
+
+		case "--dev":
+			devMode = true
+
+		case "--symlinks":
+			if i+1 < len(args) {
+				policy, err := ParseSymlinkPolicy(args[i+1])
+				if err != nil {
+					return nil, nil, err
+				}
+				symlinkPolicy = policy
+				i++
+			} else {
+				return nil, nil, errors.New("missing policy after " + arg)
+			}
+
+		case "--serve-dotfiles":
+			serveDotfiles = true
+
+		case "--serve-partials":
+			servePartials = true
+
+		case "--security-headers":
+			securityHeaders = true
+
+		case "--no-security-headers":
+			noSecurityHeaders = true
+
+		case "--csp":
+			if i+1 < len(args) {
+				securityInfo.CSP, cspGiven = args[i+1], true
+				switch securityInfo.CSP {
+				case "none":
+					securityInfo.CSP = ""
+				case "default":
+					securityInfo.CSP = DefaultContentSecurityPolicy
+				}
+				securityHeaders = true
+				i++
+			} else {
+				return nil, nil, errors.New("missing policy after " + arg)
+			}
+
+		case "--hsts":
+			if i+1 < len(args) {
+				hsts, hstsGiven = args[i+1], true
+				if hsts == "none" {
+					hsts = ""
+				}
+				i++
+			} else {
+				return nil, nil, errors.New("missing value after " + arg)
+			}
+
+		case "--http-address":
+			if i+1 < len(args) {
+				httpAddress = args[i+1]
+				i++
+			} else {
+				return nil, nil, errors.New("missing address after " + arg)
+			}
+
+		case "--tls-min-version", "--tls-ciphers", "--tls-curves", "--client-ca", "--client-cert-path":
+			if i+1 >= len(args) {
+				return nil, nil, errors.New("missing value after " + arg)
+			}
+
+			var err error
+			switch arg {
+			case "--tls-min-version":
+				tlsInfo.MinVersion, err = ParseTLSVersion(args[i+1])
+			case "--tls-ciphers":
+				tlsInfo.CipherSuites, err = ParseCipherSuites(args[i+1])
+			case "--tls-curves":
+				tlsInfo.Curves, err = ParseCurves(args[i+1])
+			case "--client-ca":
+				if err = checkFileExists(args[i+1]); err != nil {
+					err = fmt.Errorf("client ca %w", err)
+				}
+				tlsInfo.ClientCAPath = args[i+1]
+			case "--client-cert-path":
+				tlsInfo.ClientCertPaths = append(tlsInfo.ClientCertPaths, args[i+1])
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			i++
+
+		case "--sites":
+			if i+1 < len(args) {
+				sitesPath = args[i+1]
+				i++
+
+				if err := checkFileExists(sitesPath); err != nil {
+					return nil, nil, fmt.Errorf("sites config %w", err)
+				}
+			} else {
+				return nil, nil, errors.New("missing config path after " + arg)
+			}
+
+		case "--socket-mode":
+			if i+1 < len(args) {
+				mode, err := ParseSocketMode(args[i+1])
+				if err != nil {
+					return nil, nil, err
+				}
+				socketMode = mode
+				i++
+			} else {
+				return nil, nil, errors.New("missing mode after " + arg)
+			}
+
+		case "--http-health-check":
+			httpHealthCheck = true
+
+		case "--csp-report-only":
+			securityInfo.CSPReportOnly = true
+			securityHeaders = true
+
 		case "-t", "--rsstitle":
 			if i+1 < len(args) {
 				rssInfo.Title = args[i+1]
@@ -197,15 +647,15 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 
 				// Check if keyPath is a valid file
 				if err := checkFileExists(keyPath); err != nil {
-					return nil, nil, nil, fmt.Errorf("private key %w", err)
+					return nil, nil, fmt.Errorf("private key %w", err)
 				}
 			} else {
-				return nil, nil, nil, errors.New("missing private key path after " + arg)
+				return nil, nil, errors.New("missing private key path after " + arg)
 			}
 
 		case "-h", "--help":
 			fmt.Fprint(printDest, help)
-			return nil, nil, nil, errors.New("helped")
+			return nil, nil, errors.New("helped")
 		default:
 			remainingArgs = append(remainingArgs, arg)
 		}
@@ -213,7 +663,11 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 
 	// Validate that if one of certPath or keyPath is set, the other must be set as well
 	if (certPath != "" && keyPath == "") || (certPath == "" && keyPath != "") {
-		return nil, nil, nil, errors.New("both --cert and --privateKey must be provided together")
+		return nil, nil, errors.New("both --cert and --privateKey must be provided together")
+	}
+
+	if useAcme && certPath != "" {
+		return nil, nil, errors.New("--acme gets its own certificates, so it can't be used with --cert and --privatekey")
 	}
 
 	var cert *CertInfo
@@ -224,7 +678,65 @@ func ParseOpts(args []string, printDest io.Writer) (*CertInfo, *RssInfo, []strin
 		}
 	}
 
-	return cert, rssInfo, remainingArgs, nil
+	if useAcme {
+		cert = &CertInfo{Acme: acmeInfo}
+	}
+
+	// With --sites, the sites can bring certificates of their own instead.
+	servesTLS := cert != nil
+	if sitesPath != "" && !servesTLS {
+		config, err := LoadSitesConfig(sitesPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if servesTLS, err = sitesServeTLS(config.Sites, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if httpAddress != "" && !servesTLS {
+		return nil, nil, errors.New("--http-address redirects to https, so it needs --cert and --privatekey, --acme, or sites with certificates of their own")
+	}
+
+	if len(tlsInfo.ClientCertPaths) > 0 && tlsInfo.ClientCAPath == "" {
+		return nil, nil, errors.New("--client-cert-path needs --client-ca, to check client certificates against")
+	}
+
+	if len(tlsInfo.ClientCertPaths) > 0 && !servesTLS {
+		return nil, nil, errors.New("--client-cert-path needs https, from --cert and --privatekey, --acme, or sites with certificates of their own")
+	}
+
+	securityInfo.Headers = (servesTLS || securityHeaders) && !noSecurityHeaders
+
+	// A policy that blocks what it shouldn't breaks the site, so one is only sent when asked
+	// for. Trying one out in report-only mode can't break anything, so that's the default
+	// policy unless --csp gives another.
+	if securityInfo.CSPReportOnly && !cspGiven {
+		securityInfo.CSP = DefaultContentSecurityPolicy
+	}
+
+	securityInfo.HSTS = hsts
+	if !hstsGiven && securityInfo.Headers {
+		securityInfo.HSTS = DefaultHSTS
+	}
+
+	return &Options{
+		CertInfo:        cert,
+		RssInfo:         rssInfo,
+		RobotsInfo:      robotsInfo,
+		SecurityInfo:    securityInfo,
+		TLSInfo:         tlsInfo,
+		MaxPartialDepth: partialDepth,
+		DevMode:         devMode,
+		ServeDotfiles:   serveDotfiles,
+		ServePartials:   servePartials,
+		SymlinkPolicy:   symlinkPolicy,
+		HTTPAddress:     httpAddress,
+		HTTPHealthCheck: httpHealthCheck,
+		SitesPath:       sitesPath,
+		SocketMode:      socketMode,
+	}, remainingArgs, nil
 }
 
 // ParseArgs ensures command line arguments override the default settings for a new Andrew server.
@@ -1,11 +1,14 @@
 package andrew
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,7 +17,6 @@ import (
 	"syscall"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -23,36 +25,48 @@ import (
 // When a URL is requested, Server creates an Page for the file referenced
 // in that URL and then serves the Page.
 type Server struct {
-	SiteFiles                     fs.FS   // The files being served
-	BaseUrl                       string  // The URL used in any links generated for this website that should contain the hostname.
-	Address                       string  // IpAddress:Port combo to be served on.
-	Andrewtableofcontentstemplate string  // The string we're searching for inside a Page that should be replaced with a template.
-	RssInfo                       RssInfo // An RssInfo struct, so we know what we're serving for RSS information. Its Dir is expected to arrive already resolved: normalised, and known to exist in SiteFiles.
+	SiteFiles                     fs.FS      // The files being served
+	BaseUrl                       string     // The URL used in any links generated for this website that should contain the hostname.
+	Address                       string     // IpAddress:Port combo to be served on.
+	Andrewtableofcontentstemplate string     // The string we're searching for inside a Page that should be replaced with a template.
+	RssInfo                       RssInfo    // An RssInfo struct, so we know what we're serving for RSS information. Its Dir is expected to arrive already resolved: normalised, and known to exist in SiteFiles.
+	RobotsInfo                    RobotsInfo // What the generated robots.txt asks crawlers to stay out of, on top of what the pages themselves ask for.
+	DevMode                       bool       // Show the details of a page that can't be rendered to whoever asked for it, rather than a plain 500.
+	SecurityInfo                  SecurityInfo
+	TLSInfo                       TLSInfo
+	ServeDotfiles                 bool        // Serve files under dotted paths like .git, which are otherwise answered with a 404. .well-known is always served.
+	ServePartials                 bool        // Serve partial and layout files, which are otherwise answered with a 404.
+	HTTPAddress                   string      // When serving https, where to listen for plain http and redirect it to https.
+	HTTPHealthCheck               bool        // Answer health checks at /healthz on HTTPAddress, rather than redirecting them.
+	MaxPartialDepth               int         // How deeply partials can be nested inside partials. A page's own partials are at depth 1.
+	SocketMode                    fs.FileMode // The permissions of the unix sockets Andrew listens on, when Address or HTTPAddress is unix:/path.
 	HTTPServer                    *http.Server
+
+	redirectServer *http.Server // Listens for plain http at HTTPAddress, or for ACME's HTTP-01 challenges.
+	stopCertReload func()       // Stops reloading the certificate on SIGHUP.
+	siteName       string       // The site's label in metrics, when it's one of several served together.
+
+	state *serverState // Shared by every copy of the Server, so its value receivers see the same tables.
+}
+
+// serverState is what a Server keeps up to date while it runs: the site's redirects, headers
+// and ignored files, and how many CSP reports it has logged.
+type serverState struct {
+	redirects  redirectTable
+	headers    headerTable
+	ignores    ignoreTable
+	cspReports reportLimiter // How many CSP reports have been logged this minute.
 }
 
-// allRequestsCounter tracks the total number of requests made.
-// Note there can be many requests for a single page, as css etc is served.
-var allRequestsCounter = promauto.NewCounter(prometheus.CounterOpts{
-	Name: "andrew_server_serve_allrequests",
-	Help: "The total number of all requests received by the andrew server",
-})
-
-// allRequestsErrorsAggregatedCounter tracks all of the error codes generated,
-// aggregated into one number. The aggregation is to reduce the cardinality of the metrics in Prometheus, which can
-// get fairly gnarly as bots and scammers try downloading random URLs from the website.
-var allRequestsErrorsAggregatedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
-	Name: "andrew_server_serve_allrequests_errorsbypath",
-	Help: "The total number of all non-http 200 requests received by the andrew server",
-}, []string{"path", "status"})
-
-// allHttp200RequestsByPathCounter tracks all of the http 200 paths served,
-// organised by the path that is successfully served.
-// Note there can be many requests for a single page, as css etc is served.
-var allHttp200RequestsByPathCounter = promauto.NewCounterVec(prometheus.CounterOpts{
-	Name: "andrew_server_serve_allrequests_200bypath",
-	Help: "The total number of all http 200 requests received by the andrew server, segregated by path",
-}, []string{"path", "status"})
+// shared is the Server's state. A Server that wasn't made by NewServer hasn't got any, so it
+// gets an empty one each time, and reads the site's _redirects, _headers and .andrewignore
+// afresh whenever it needs them.
+func (a *Server) shared() *serverState {
+	if a.state == nil {
+		return &serverState{}
+	}
+	return a.state
+}
 
 // NewServer builds your web server.
 // siteFiles: an fs.FS of the files that you're serving.
@@ -67,12 +81,19 @@ func NewServer(siteFiles fs.FS, address, baseUrl string, rssInfo RssInfo) *Serve
 		Address:                       address,
 		BaseUrl:                       baseUrl,
 		RssInfo:                       rssInfo,
+		MaxPartialDepth:               DefaultMaxPartialDepth,
+		SocketMode:                    DefaultSocketMode,
+		state:                         &serverState{},
 	}
 
+	s.state.headers.load(siteFiles)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.Serve)
-	mux.HandleFunc("/sitemap.xml", s.ServeSiteMap)
-	mux.HandleFunc("/rss.xml", s.ServeRssFeed)
+	mux.HandleFunc("/", s.instrumentBy(pageOrAsset, s.withHeaders(allowMethods(s.current(Server.Serve)))))
+	mux.HandleFunc("/sitemap.xml", s.instrument("sitemap", s.withHeaders(allowMethods(s.current(Server.ServeSiteMap)))))
+	mux.HandleFunc("/rss.xml", s.instrument("rss", s.withHeaders(allowMethods(s.current(Server.ServeRssFeed)))))
+	mux.HandleFunc("/robots.txt", s.instrument("robots", s.withHeaders(allowMethods(s.current(Server.ServeRobotsTxt)))))
+	mux.HandleFunc(cspReportPath, s.current(Server.ServeCSPReport))
 	mux.Handle("/metrics", promhttp.Handler())
 
 	s.HTTPServer = &http.Server{
@@ -83,10 +104,30 @@ func NewServer(siteFiles fs.FS, address, baseUrl string, rssInfo RssInfo) *Serve
 	return s
 }
 
+// partialDepth is how deeply the site's partials can be nested: MaxPartialDepth, or
+// DefaultMaxPartialDepth for a Server that wasn't made by NewServer and hasn't set it.
+// current serves each request with serve on the Server as it is when the request arrives,
+// rather than a copy of it taken when the handler was made, so fields set after NewServer,
+// like DevMode or SecurityInfo, count.
+func (a *Server) current(serve func(Server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve(*a, w, r)
+	}
+}
+
+func (a *Server) partialDepth() int {
+	if a.MaxPartialDepth < 1 {
+		return DefaultMaxPartialDepth
+	}
+
+	return a.MaxPartialDepth
+}
+
 // logRequest emits one access-log line per request so that traffic can be
 // analyzed with ordinary log tooling. It records the client's remote address,
-// the requested path, and the User-Agent and Referer the client claims, which
-// is what's needed to spot bots that impersonate Googlebot.
+// the requested path and query string, and the User-Agent and Referer the client claims, which
+// is what's needed to spot bots that impersonate Googlebot. A client that made the request
+// with a verified client certificate is identified by the certificate's subject.
 //
 // When Andrew runs behind a reverse proxy (e.g. Traefik), r.RemoteAddr is the
 // proxy's container IP, not the real client. logRequest reads X-Forwarded-For
@@ -105,9 +146,12 @@ func logRequest(r *http.Request) {
 
 	slog.Info("request",
 		"remote_addr", clientIP,
-		"path", r.RequestURI,
+		"path", r.URL.Path,
+		"query", r.URL.RawQuery,
 		"user_agent", r.UserAgent(),
 		"referer", r.Referer(),
+		"host", r.Host,
+		"client_cert", clientIdentity(r),
 	)
 }
 
@@ -115,6 +159,9 @@ func logRequest(r *http.Request) {
 // an index.html page or for anything else (another page, css, javascript etc).
 // If a directory is requested, Serve defaults to finding the index.html page
 // within that directory.
+// Each page has one address: a directory is redirected to its address with a trailing
+// slash, and an index.html to its directory's address, so /blog, /blog/ and
+// /blog/index.html all end up at /blog/.
 // This is a standard http serve function, and takes the normal writer and request.
 // Args:
 // w http.ResponseWriter - a ResponseWriter to write streams to.
@@ -123,51 +170,167 @@ func (a Server) Serve(w http.ResponseWriter, r *http.Request) {
 
 	logRequest(r)
 
-	pagePath := path.Clean(r.RequestURI)
 	allRequestsCounter.Inc()
 
-	// Ensure the pagePath is relative to the root of a.SiteFiles.
-	// This involves trimming a leading slash if present.
-	pagePath = strings.TrimPrefix(pagePath, "/")
+	if a.serveRedirect(w, r) {
+		return
+	}
+
+	// r.URL.Path is already decoded, so a file called "my page.html" is found from
+	// /my%20page.html. Ensure the pagePath is relative to the root of a.SiteFiles.
+	// This involves trimming a leading slash.
+	urlPath := path.Clean("/" + r.URL.Path)
+	pagePath := strings.TrimPrefix(urlPath, "/")
+
+	// A split sitemap's parts are generated, like sitemap.xml itself, but the mux can't
+	// route a pattern like sitemap-{n}.xml, so they arrive here. A file of that name in the
+	// site is yours, and served like any other.
+	if m := siteMapPartFinder.FindStringSubmatch(pagePath); m != nil {
+		if _, err := fs.Stat(a.SiteFiles, pagePath); errors.Is(err, fs.ErrNotExist) {
+			n, _ := strconv.Atoi(m[1])
+			if a.serveSiteMapPart(w, n) {
+				return
+			}
+		}
+	}
 
 	maybeDir, _ := fs.Stat(a.SiteFiles, pagePath)
+	isDir := maybeDir != nil && maybeDir.IsDir()
 
 	// In most cases, pagePath does not need to be manipulated.
-	// There are three cases where we need to append "index.html" to the pagePath, though:
+	// There are two cases where we need to append "index.html" to the pagePath, though:
 	// 1. If we receive a request for a directory within the file system, the default file to serve is index.html
-	// 2. If we receive a request for www.example.com/, pagePath will be /. This means "please serve the index.html
-	//    in whatever directory the web server is started from."
-	// 3. If we receive a request for www.example.com, pagePath will be an empty string. We should serve index.html.
+	// 2. If we receive a request for www.example.com/, pagePath will be an empty string. We should serve index.html.
 	switch {
-	case maybeDir != nil && maybeDir.IsDir():
-		pagePath = pagePath + "/index.html"
-	case strings.HasSuffix(pagePath, "/"):
-		pagePath = "index.html"
+	case isDir:
+		pagePath = path.Join(pagePath, "index.html")
 	case pagePath == "":
 		pagePath = "index.html"
 	}
 
+	if a.isHidden(pagePath) {
+		status := a.serveError(w, pagePath, fs.ErrNotExist)
+		allRequestsErrorsAggregatedCounter.WithLabelValues(a.siteName, "Failed Page", strconv.Itoa(status)).Inc()
+		return
+	}
+
+	// The error page comes from the root of the site, as one in the private area would be as
+	// private as the rest of it.
+	if a.needsClientCert(pagePath) && clientIdentity(r) == "" {
+		status := a.serveError(w, "index.html", fs.ErrPermission)
+		allRequestsErrorsAggregatedCounter.WithLabelValues(a.siteName, "Failed Page", strconv.Itoa(status)).Inc()
+		return
+	}
+
+	// Hidden and private paths have been answered by now, so a redirect can't give away that
+	// a hidden directory exists.
+	switch {
+	case isDir && !strings.HasSuffix(r.URL.Path, "/"):
+		a.redirectToCanonical(w, r, urlPath+"/")
+		return
+	case path.Base(urlPath) == "index.html":
+		a.redirectToCanonical(w, r, strings.TrimSuffix(urlPath, "index.html"))
+		return
+	}
+
+	// A Markdown page's address is post.html, like the html page it stands in for. post and
+	// post/ find it too, so they're sent there.
+	if !isDir && path.Ext(pagePath) == "" && maybeDir == nil {
+		if source, ok := markdownSourceFor(a.SiteFiles, pagePath); ok {
+			a.redirectToCanonical(w, r, "/"+markdownUrlPath(source))
+			return
+		}
+	}
+
 	page, err := a.NewPage(pagePath)
+
+	var renderErr *RenderError
+	if errors.As(err, &renderErr) {
+		a.serveRenderError(w, r, pagePath, renderErr)
+		return
+	}
+
 	if err != nil {
-		message, status := CheckPageErrors(err)
-		w.WriteHeader(status)
-		fmt.Fprint(w, message)
-		allRequestsErrorsAggregatedCounter.WithLabelValues("Failed Page", strconv.Itoa(status)).Inc()
+		status := a.serveError(w, pagePath, err)
+		allRequestsErrorsAggregatedCounter.WithLabelValues(a.siteName, "Failed Page", strconv.Itoa(status)).Inc()
 		return
 	}
 
 	a.serve(w, page)
 }
 
+// ListenAndServe serves http at the server's Address, which can be host:port, a unix socket
+// like unix:/run/andrew.sock, or systemd for a socket systemd passed in.
 func (a *Server) ListenAndServe() error {
-	return a.HTTPServer.ListenAndServe()
+	a.shared().redirects.start(a.SiteFiles, a.partialDepth())
+
+	listener, err := listen(a.Address, a.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	return a.HTTPServer.Serve(listener)
 }
 
+// ListenAndServeTLS serves https with the certificate and key at certPath and privateKeyPath.
+// They're loaded again when either file changes, or when Andrew gets a SIGHUP, so a renewed
+// certificate doesn't need a restart. With HTTPAddress, plain http is redirected to https.
+// TLSInfo sets the versions, cipher suites and curves allowed, and the CA client
+// certificates are checked against.
 func (a *Server) ListenAndServeTLS(certPath string, privateKeyPath string) error {
-	return a.HTTPServer.ListenAndServeTLS(certPath, privateKeyPath)
+	a.shared().redirects.start(a.SiteFiles, a.partialDepth())
+
+	reloader, err := newCertReloader(certPath, privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	if a.HTTPServer.TLSConfig == nil {
+		a.HTTPServer.TLSConfig = &tls.Config{}
+	}
+	a.HTTPServer.TLSConfig.GetCertificate = reloader.GetCertificate
+
+	if err := a.configureTLS(a.HTTPServer.TLSConfig); err != nil {
+		return err
+	}
+
+	listener, err := listen(a.Address, a.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	a.stopCertReload = reloader.reloadOnSIGHUP()
+
+	if a.HTTPAddress != "" {
+		a.listenForHTTP(a.HTTPAddress, a.httpsRedirect())
+	}
+
+	return a.HTTPServer.ServeTLS(listener, "", "")
+}
+
+// Shutdown stops listening, and stops serving once the requests in flight have been
+// answered, or ctx is done.
+func (a *Server) Shutdown(ctx context.Context) error {
+	if a.redirectServer != nil {
+		a.redirectServer.Shutdown(ctx)
+	}
+
+	if a.stopCertReload != nil {
+		a.stopCertReload()
+	}
+
+	return a.HTTPServer.Shutdown(ctx)
 }
 
 func (a *Server) Close() error {
+	if a.redirectServer != nil {
+		a.redirectServer.Close()
+	}
+
+	if a.stopCertReload != nil {
+		a.stopCertReload()
+	}
+
 	return a.HTTPServer.Close()
 }
 
@@ -179,6 +342,7 @@ func (a Server) serve(w http.ResponseWriter, page Page) {
 		w.Header().Set("Content-Type", "text/css; charset=utf-8")
 	case ".html":
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		a.setPageContentSecurityPolicy(w, page.Content)
 	case ".js":
 		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
 	case ".jpg":
@@ -194,10 +358,66 @@ func (a Server) serve(w http.ResponseWriter, page Page) {
 	}
 
 	w.WriteHeader(200)
-	allHttp200RequestsByPathCounter.WithLabelValues(page.UrlPath, strconv.Itoa(200)).Inc()
+	allHttp200RequestsByPathCounter.WithLabelValues(a.siteName, trackedPaths.label(a.siteName, page.UrlPath), strconv.Itoa(200)).Inc()
 	fmt.Fprint(w, page.Content)
 }
 
+// redirectToCanonical sends a request on to urlPath, the page's one true address, keeping
+// its query string.
+func (a *Server) redirectToCanonical(w http.ResponseWriter, r *http.Request, urlPath string) {
+	target := escapeUrlPath(urlPath)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// canonicalUrlPath is the address of the page at pagePath, relative to the root of the site:
+// the one it's listed under, and redirected to from its other addresses. An index page's is
+// its directory's.
+func canonicalUrlPath(pagePath string) string {
+	if path.Base(pagePath) == "index.html" {
+		pagePath = strings.TrimSuffix(pagePath, "index.html")
+	}
+
+	return "/" + pagePath
+}
+
+// escapeUrlPath escapes the characters in a path that can't go in a URL as they are, like
+// spaces, for links to files with them in their names.
+func escapeUrlPath(urlPath string) string {
+	return (&url.URL{Path: urlPath}).EscapedPath()
+}
+
+// serveError answers a request for pagePath that failed with err, and returns the status it
+// answered with. A site can have its own page for each status, like 404.html, found by
+// searching upwards from pagePath the same way partials are, so a section of the site can
+// have its own. It's rendered like any other page. Without one, or if it can't be rendered
+// either, the answer is CheckPageErrors' plain text.
+func (a *Server) serveError(w http.ResponseWriter, pagePath string, err error) int {
+	message, status := CheckPageErrors(err)
+
+	errorPagePath, err := findPartialFile(a.SiteFiles, pagePath, strconv.Itoa(status)+".html")
+	if err == nil {
+		errorPage, err := a.NewPage(errorPagePath)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			a.setPageContentSecurityPolicy(w, errorPage.Content)
+			w.WriteHeader(status)
+			fmt.Fprint(w, errorPage.Content)
+			return status
+		}
+
+		slog.Error("error page could not be rendered", "path", errorPagePath, "error", err)
+	}
+
+	w.WriteHeader(status)
+	fmt.Fprint(w, message)
+
+	return status
+}
+
 // CheckPageErrors is a helper function that will convert an error handed into it
 // into the appropriate http error code and a message.
 // If no specific error is found, a 500 is the default value returned.
@@ -226,9 +446,12 @@ func CheckPageErrors(err error) (string, int) {
 func (a Server) GetSiblingsAndChildren(pagePath string) ([]Page, error) {
 	slog.Debug("GetSiblingsAndChildren", "pagePath", pagePath)
 
+	timer := prometheus.NewTimer(renderPhaseDuration.WithLabelValues("toc_walk"))
+	defer timer.ObserveDuration()
+
 	localContentRoot := path.Dir(pagePath)
 
-	pages, err := pagesInDir(a.SiteFiles, localContentRoot)
+	pages, err := pagesInDir(a.listedFiles(strings.TrimPrefix(pagePath, "/")), localContentRoot, a.partialDepth())
 	if err != nil {
 		return nil, err
 	}
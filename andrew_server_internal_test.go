@@ -0,0 +1,62 @@
+package andrew
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// recordingFS remembers which files have been opened since it was last reset.
+type recordingFS struct {
+	fs.FS
+	mu     *sync.Mutex
+	opened map[string]bool
+}
+
+func (r recordingFS) Open(name string) (fs.File, error) {
+	r.mu.Lock()
+	r.opened[name] = true
+	r.mu.Unlock()
+	return r.FS.Open(name)
+}
+
+func (r recordingFS) reset() {
+	r.mu.Lock()
+	clear(r.opened)
+	r.mu.Unlock()
+}
+
+func TestNotFoundPageDoesNotReadTheRestOfTheSite(t *testing.T) {
+	t.Parallel()
+
+	site := recordingFS{
+		FS: fstest.MapFS{
+			"404.html":        {Data: []byte(`<title>Not found</title>`)},
+			"index.html":      {Data: []byte(`<title>Home</title>`)},
+			"blog/first.html": {Data: []byte(`<title>First</title>`)},
+		},
+		mu:     &sync.Mutex{},
+		opened: map[string]bool{},
+	}
+	server := NewServer(site, "localhost:0", "http://localhost", RssInfo{})
+
+	// The first request waits for the redirect aliases, which means reading every page.
+	server.HTTPServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	site.reset()
+
+	recorder := httptest.NewRecorder()
+	server.HTTPServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/missing.html", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected a 404, got %d", recorder.Code)
+	}
+
+	for _, page := range []string{"index.html", "blog/first.html"} {
+		if site.opened[page] {
+			t.Errorf("expected the 404 page not to read %s", page)
+		}
+	}
+}
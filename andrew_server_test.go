@@ -21,6 +21,21 @@ import (
 	"github.com/playtechnique/andrew"
 )
 
+// A Server value, not only a *Server, has always been able to serve and build pages.
+func TestServerMethodsTakeAServerValue(t *testing.T) {
+	t.Parallel()
+
+	_ = []any{
+		andrew.Server.Serve,
+		andrew.Server.NewPage,
+		andrew.Server.GetSiblingsAndChildren,
+		andrew.Server.ServeSiteMap,
+		andrew.Server.ServeRssFeed,
+		andrew.Server.ServeRobotsTxt,
+		andrew.Server.ServeCSPReport,
+	}
+}
+
 func TestServerRespondsStatusOKForExistingPage(t *testing.T) {
 	t.Parallel()
 	expected := []byte(`
@@ -93,6 +108,98 @@ func TestGetForUnreadablePageGeneratesStatusForbidden(t *testing.T) {
 	}
 }
 
+func TestGetForNonExistentPageServesTheNearestErrorPage(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		".AndrewPartialFileNav.html": {Data: []byte(`<nav>home</nav>`)},
+		"404.html":                   {Data: []byte(`{{ .AndrewPartialFileNav.html }}<p>not here</p>`)},
+		"blog/404.html":              {Data: []byte(`<p>no such post</p>`)},
+		"blog/index.html":            {Data: []byte(`<title>blog</title>`)},
+	})
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/missing.html", expected: `<nav>home</nav><p>not here</p>`},
+		{path: "/blog/missing.html", expected: `<p>no such post</p>`},
+		{path: "/blog/old/missing.html", expected: `<p>no such post</p>`},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Get(s.BaseUrl + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected a 404, received %d", tc.path, resp.StatusCode)
+		}
+
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			t.Errorf("%s: expected an html content type, received %q", tc.path, resp.Header.Get("Content-Type"))
+		}
+
+		if string(received) != tc.expected {
+			t.Errorf("%s: expected %q, received %q", tc.path, tc.expected, received)
+		}
+	}
+}
+
+func TestErrorPageThatCannotBeRenderedFallsBackToPlainText(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"404.html": {Data: []byte(`{{ .Page.Nope }}`)},
+	})
+
+	resp, err := http.Get(s.BaseUrl + "/missing.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusNotFound || string(received) != "404 not found" {
+		t.Errorf("expected the plain 404, received %d %q", resp.StatusCode, received)
+	}
+}
+
+func TestPageWithATemplateMistakeServesTheErrorPage(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"500.html":   {Data: []byte(`<p>sorry</p>`)},
+		"index.html": {Data: []byte(`{{ .Page.Nope }}`)},
+	})
+
+	resp, err := http.Get(s.BaseUrl + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusInternalServerError || string(received) != `<p>sorry</p>` {
+		t.Errorf("expected the 500 page, received %d %q", resp.StatusCode, received)
+	}
+}
+
 func Test500ErrorForUnforeseenErrorCase(t *testing.T) {
 	t.Parallel()
 
@@ -524,3 +631,87 @@ func TestGetSiblingsAndChildrenFiltersHtmlFilesCorrectly(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestGetSiblingsAndChildrenLeavesOutErrorPages(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"index.html":    {Data: []byte("<title>Home</title>")},
+		"page.html":     {Data: []byte("<title>Page</title>")},
+		"404.html":      {Data: []byte("<title>Not found</title>")},
+		"blog/500.html": {Data: []byte("<title>Broken</title>")},
+	}}
+
+	siblings, err := server.GetSiblingsAndChildren("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, sibling := range siblings {
+		got = append(got, sibling.UrlPath)
+	}
+
+	if diff := cmp.Diff([]string{"page.html"}, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestServeIgnoresTheQueryStringWhenFindingThePage(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"page.html": {Data: []byte(`<title>page</title>`)},
+	})
+
+	requireStatus(t, s.BaseUrl+"/page.html?utm_source=newsletter&utm_medium=email", http.StatusOK)
+}
+
+func TestServeFindsFilesWithEncodedNames(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"my page.html":    {Data: []byte(`<title>spaces</title>`)},
+		"café/menu.html":  {Data: []byte(`<title>unicode</title>`)},
+		"index.html":      {Data: []byte(`{{ .AndrewTableOfContents }}`)},
+		"notes/100%.html": {Data: []byte(`<title>percent</title>`)},
+	})
+
+	requireStatus(t, s.BaseUrl+"/my%20page.html", http.StatusOK)
+	requireStatus(t, s.BaseUrl+"/caf%C3%A9/menu.html", http.StatusOK)
+	requireStatus(t, s.BaseUrl+"/notes/100%25.html", http.StatusOK)
+
+	resp, err := http.Get(s.BaseUrl + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`href="my%20page.html"`, `href="caf%C3%A9/menu.html"`, `href="notes/100%25.html"`} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected the table of contents to link with %s, received %s", expected, body)
+		}
+	}
+}
+
+func TestServeRedirectsToEachPagesCanonicalAddress(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html":      {Data: []byte(`<title>home</title>`)},
+		"blog/index.html": {Data: []byte(`<title>blog</title>`)},
+	})
+
+	requireRedirect(t, s.BaseUrl+"/blog", http.StatusMovedPermanently, "/blog/")
+	requireRedirect(t, s.BaseUrl+"/blog?page=2", http.StatusMovedPermanently, "/blog/?page=2")
+	requireRedirect(t, s.BaseUrl+"/blog/index.html", http.StatusMovedPermanently, "/blog/")
+	requireRedirect(t, s.BaseUrl+"/index.html", http.StatusMovedPermanently, "/")
+
+	requireStatus(t, s.BaseUrl+"/blog/", http.StatusOK)
+	requireStatus(t, s.BaseUrl+"/", http.StatusOK)
+}
@@ -0,0 +1,177 @@
+package andrew
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreFile is the file in the root of the site listing the files Andrew should neither serve
+// nor list, in the same syntax as a .gitignore.
+const ignoreFile = ".andrewignore"
+
+// ignoreRule is one pattern from an ignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negated bool // The pattern started with !, so matching files are no longer ignored.
+	dirOnly bool // The pattern ended with /, so it only matches directories.
+}
+
+// ignoreRules are the patterns from an ignore file, in the order it lists them.
+type ignoreRules []ignoreRule
+
+// readIgnoreFile reads the site's ignore file. A site without one ignores nothing.
+func readIgnoreFile(siteFiles fs.FS) ignoreRules {
+	content, err := fs.ReadFile(siteFiles, ignoreFile)
+	if err != nil {
+		return nil
+	}
+
+	return parseIgnoreFile(content)
+}
+
+// parseIgnoreFile reads the patterns in an ignore file. It understands what a .gitignore
+// does: # comments, ! to un-ignore, a trailing / for directories only, a leading or middle
+// / to anchor a pattern to the root of the site, and *, ?, [...] and **.
+func parseIgnoreFile(content []byte) ignoreRules {
+	rules := ignoreRules{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+
+		if negated, found := strings.CutPrefix(line, "!"); found {
+			rule.negated, line = true, negated
+		}
+		line = strings.TrimPrefix(line, `\`)
+
+		if dir, found := strings.CutSuffix(line, "/"); found {
+			rule.dirOnly, line = true, dir
+		}
+
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		expr := globToRegexp(line)
+		if !anchored {
+			expr = "(?:.*/)?" + expr
+		}
+
+		pattern, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			slog.Warn("skipping ignore pattern", "path", ignoreFile, "pattern", scanner.Text(), "error", err)
+			continue
+		}
+		rule.pattern = pattern
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// globToRegexp translates a gitignore glob into a regular expression. * and ? don't match a
+// /, but ** matches any number of directories.
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		case glob[i] == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				expr.WriteString(regexp.QuoteMeta(glob[i:]))
+				return expr.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if rest, found := strings.CutPrefix(class, "!"); found {
+				class = "^" + rest
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return expr.String()
+}
+
+// ignores reports whether filePath, relative to the root of the site, is ignored. As with
+// git, a file in an ignored directory is ignored whatever the patterns say about the file.
+func (rules ignoreRules) ignores(filePath string, isDir bool) bool {
+	if len(rules) == 0 {
+		return false
+	}
+
+	segments := strings.Split(path.Clean(filePath), "/")
+	for i := range segments {
+		last := i == len(segments)-1
+		if rules.matchLast(strings.Join(segments[:i+1], "/"), !last || isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchLast is the verdict of the last pattern that matches filePath, which is the one that
+// counts.
+func (rules ignoreRules) matchLast(filePath string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		if rule.pattern.MatchString(filePath) {
+			ignored = !rule.negated
+		}
+	}
+
+	return ignored
+}
+
+// ignoreTable is a site's ignore file, read again whenever it changes. Its zero value is
+// ready to use.
+type ignoreTable struct {
+	mu      sync.Mutex
+	version fileVersion
+	rules   ignoreRules
+}
+
+// current is the ignore file's rules as the file is now.
+func (t *ignoreTable) current(siteFiles fs.FS) ignoreRules {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if version := currentFileVersion(siteFiles, ignoreFile); !version.same(t.version) {
+		t.rules, t.version = readIgnoreFile(siteFiles), version
+	}
+
+	return t.rules
+}
@@ -0,0 +1,46 @@
+package andrew
+
+import "testing"
+
+func TestIgnoreRules(t *testing.T) {
+	t.Parallel()
+
+	rules := parseIgnoreFile([]byte(`# build output
+*.log
+/notes.html
+drafts/
+docs/**/internal.html
+secret-[0-9].html
+!important.log
+private/
+!private/public.html
+`))
+
+	testCases := []struct {
+		filePath string
+		isDir    bool
+		ignored  bool
+	}{
+		{filePath: "debug.log", ignored: true},
+		{filePath: "logs/debug.log", ignored: true},
+		{filePath: "important.log", ignored: false},
+		{filePath: "notes.html", ignored: true},
+		{filePath: "blog/notes.html", ignored: false},
+		{filePath: "drafts", isDir: true, ignored: true},
+		{filePath: "blog/drafts/post.html", ignored: true},
+		{filePath: "drafts.html", ignored: false},
+		{filePath: "docs/internal.html", ignored: true},
+		{filePath: "docs/a/b/internal.html", ignored: true},
+		{filePath: "secret-1.html", ignored: true},
+		{filePath: "secret-x.html", ignored: false},
+		// A file in an ignored directory can't be un-ignored, as with git.
+		{filePath: "private/public.html", ignored: true},
+		{filePath: "index.html", ignored: false},
+	}
+
+	for _, tc := range testCases {
+		if received := rules.ignores(tc.filePath, tc.isDir); received != tc.ignored {
+			t.Errorf("%s: expected ignored to be %t, received %t", tc.filePath, tc.ignored, received)
+		}
+	}
+}
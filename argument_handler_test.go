@@ -2,10 +2,11 @@ package andrew_test
 
 import (
 	"bytes"
-	"fmt"
+	"crypto/tls"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/playtechnique/andrew"
 )
 
@@ -62,95 +63,379 @@ func TestMainCalledWithArgsOverridesDefaults(t *testing.T) {
 	}
 }
 
-func TestMainCalledWithInvalidAddressPanics(t *testing.T) {
+func TestMainCalledWithInvalidAddressExitsWithAnError(t *testing.T) {
 	t.Parallel()
-	args := []string{".", "notanipaddress"}
-	nullLogger := new(bytes.Buffer)
 
-	// No need to check whether `recover()` is nil. Just turn off the panic.
-	defer func() {
-		err := recover()
-		if err == nil {
-			t.Fatalf("Expected panic with invalid address, received %v", err)
-		}
-	}()
-
-	andrew.Main(args, nullLogger)
+	requireExitWithErrorContaining(t, "notanipaddress", []string{".", "notanipaddress"})
 }
 
-func TestMainCalledWithCertOptionWithoutPathPanics(t *testing.T) {
+func TestMainCalledWithCertOptionWithoutPathExitsWithAnError(t *testing.T) {
 	t.Parallel()
 
-	requirePanicContaining(t, "missing certificate path", func() {
-		andrew.Main([]string{"--cert"}, new(bytes.Buffer))
-	})
+	requireExitWithErrorContaining(t, "missing certificate path", []string{"--cert"})
 }
 
-func TestMainCalledWithRssDirOptionWithoutPathPanics(t *testing.T) {
+func TestMainCalledWithRssDirOptionWithoutPathExitsWithAnError(t *testing.T) {
 	t.Parallel()
 
-	requirePanicContaining(t, "missing rss directory", func() {
-		andrew.Main([]string{"--rssdir"}, new(bytes.Buffer))
-	})
+	requireExitWithErrorContaining(t, "missing rss directory", []string{"--rssdir"})
 }
 
-// TestMainCalledWithAnRssDirThatIsNotInTheContentRootPanics covers Main resolving the rss
+// TestMainCalledWithAnRssDirThatIsNotInTheContentRootExitsWithAnError covers Main resolving the rss
 // dir before it builds a server, which is what makes a typo'd --rssdir fail at startup
 // rather than when someone eventually requests the feed.
-func TestMainCalledWithAnRssDirThatIsNotInTheContentRootPanics(t *testing.T) {
+func TestMainCalledWithAnRssDirThatIsNotInTheContentRootExitsWithAnError(t *testing.T) {
 	t.Parallel()
 
-	requirePanicContaining(t, "must be a directory inside the content root", func() {
-		andrew.Main([]string{"--rssdir", "does-not-exist", "testdata"}, new(bytes.Buffer))
-	})
+	requireExitWithErrorContaining(t, "must be a directory inside the content root", []string{"--rssdir", "does-not-exist", "testdata"})
 }
 
-func TestMainCalledWithPrivateKeyOptionWithoutPathPanics(t *testing.T) {
+func TestMainCalledWithPrivateKeyOptionWithoutPathExitsWithAnError(t *testing.T) {
 	t.Parallel()
 
-	requirePanicContaining(t, "missing private key path", func() {
-		andrew.Main([]string{"--privatekey"}, new(bytes.Buffer))
-	})
+	requireExitWithErrorContaining(t, "missing private key path", []string{"--privatekey"})
 }
-func TestMainCalledWithOneCertOptionWithoutTheOtherPanics(t *testing.T) {
-	t.Parallel()
 
-	requirePanicContaining(t, "must be provided together", func() {
-		andrew.Main([]string{"--cert", "testdata/test-cert.crt"}, new(bytes.Buffer))
-	})
+func TestMainCalledWithOneCertOptionWithoutTheOtherExitsWithAnError(t *testing.T) {
+	t.Parallel()
 
-	requirePanicContaining(t, "must be provided together", func() {
-		andrew.Main([]string{"--privatekey", "testdata/test-cert.crt"}, new(bytes.Buffer))
-	})
+	requireExitWithErrorContaining(t, "must be provided together", []string{"--cert", "testdata/test-cert.crt"})
+	requireExitWithErrorContaining(t, "must be provided together", []string{"--privatekey", "testdata/test-cert.crt"})
 }
 
-// requirePanicContaining fails the test unless fn panics with a value whose message
-// contains want.
+// requireExitWithErrorContaining fails the test unless Main, given args, exits with 1 and
+// tells the end user something containing want.
 //
 // Matching on a substring of the message is only appropriate because these particular
-// errors are user-facing: the panic from a bad command line is what an end user reads on
+// errors are user-facing: the message from a bad command line is what an end user reads on
 // their terminal, so the wording is part of andrew's contract with them and is a fair
 // thing to assert on. Please, do not reach for this to test an internal error, where the message
 // is an implementation detail assert on the error value with errors.Is instead.
-//
-// A deferred recover lives in here, so it is always registered before fn runs.
-// Registering a recover after the call that panics silently kills the whole test binary
-// rather than failing the test.
-func requirePanicContaining(t *testing.T, want string, fn func()) {
+func requireExitWithErrorContaining(t *testing.T, want string, args []string) {
 	t.Helper()
 
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Errorf("expected a panic containing %q, but nothing panicked", want)
-			return
+	received := new(bytes.Buffer)
+
+	if exit := andrew.Main(args, received); exit != 1 {
+		t.Errorf("expected exit value 1 for %v, received %d", args, exit)
+	}
+
+	if !strings.Contains(received.String(), want) {
+		t.Errorf("expected an error containing %q, got %q", want, received)
+	}
+}
+
+func TestParseOptsCollectsRobotsOptions(t *testing.T) {
+	t.Parallel()
+
+	opts, remaining, err := andrew.ParseOptions([]string{
+		"--robots-disallow", "/private/",
+		"contentRoot",
+		"--robots-disallow", "/drafts/",
+		"--block-crawler", "BadBot",
+		"--block-ai-crawlers",
+	}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"contentRoot"}, remaining); diff != "" {
+		t.Error(diff)
+	}
+
+	if diff := cmp.Diff([]string{"/private/", "/drafts/"}, opts.RobotsInfo.DisallowPaths); diff != "" {
+		t.Error(diff)
+	}
+
+	wantCrawlers := append([]string{"BadBot"}, andrew.DefaultAICrawlers...)
+	if diff := cmp.Diff(wantCrawlers, opts.RobotsInfo.BlockedCrawlers); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestParseOptsReadsMaxPartialDepth(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.MaxPartialDepth != andrew.DefaultMaxPartialDepth {
+		t.Errorf("expected the default depth %d, received %d", andrew.DefaultMaxPartialDepth, opts.MaxPartialDepth)
+	}
+
+	opts, _, err = andrew.ParseOptions([]string{"--max-partial-depth", "3"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.MaxPartialDepth != 3 {
+		t.Errorf("expected a depth of 3, received %d", opts.MaxPartialDepth)
+	}
+
+	for _, depth := range []string{"0", "deep"} {
+		if _, _, err := andrew.ParseOptions([]string{"--max-partial-depth", depth}, new(bytes.Buffer)); err == nil {
+			t.Errorf("expected an error for a depth of %q", depth)
 		}
+	}
+}
 
-		// fmt.Sprint copes with both panic(err) and panic("some string").
-		if got := fmt.Sprint(r); !strings.Contains(got, want) {
-			t.Errorf("expected a panic containing %q, got %q", want, got)
+func TestParseOptsReadsSocketMode(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.SocketMode != andrew.DefaultSocketMode {
+		t.Errorf("expected the default socket mode %o, received %o", andrew.DefaultSocketMode, opts.SocketMode)
+	}
+
+	opts, _, err = andrew.ParseOptions([]string{"--socket-mode", "0600"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.SocketMode != 0o600 {
+		t.Errorf("expected a socket mode of 600, received %o", opts.SocketMode)
+	}
+
+	for _, mode := range []string{"0999", "rw-rw----", "01777"} {
+		if _, _, err := andrew.ParseOptions([]string{"--socket-mode", mode}, new(bytes.Buffer)); err == nil {
+			t.Errorf("expected an error for a socket mode of %q", mode)
 		}
-	}()
+	}
+}
+
+func TestParseOptsTurnsSecurityHeadersOnForHttps(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		args     []string
+		expected andrew.SecurityInfo
+	}{
+		{
+			args:     []string{},
+			expected: andrew.SecurityInfo{},
+		},
+		{
+			args:     []string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt"},
+			expected: andrew.SecurityInfo{Headers: true, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--acme"},
+			expected: andrew.SecurityInfo{Headers: true, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt", "--no-security-headers"},
+			expected: andrew.SecurityInfo{},
+		},
+		{
+			args:     []string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt", "--csp", "default"},
+			expected: andrew.SecurityInfo{Headers: true, CSP: andrew.DefaultContentSecurityPolicy, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--csp-report-only"},
+			expected: andrew.SecurityInfo{Headers: true, CSP: andrew.DefaultContentSecurityPolicy, CSPReportOnly: true, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--csp", "default-src 'none'", "--csp-report-only"},
+			expected: andrew.SecurityInfo{Headers: true, CSP: "default-src 'none'", CSPReportOnly: true, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--security-headers", "--csp", "none"},
+			expected: andrew.SecurityInfo{Headers: true, HSTS: andrew.DefaultHSTS},
+		},
+		{
+			args:     []string{"--hsts", "max-age=300"},
+			expected: andrew.SecurityInfo{HSTS: "max-age=300"},
+		},
+		{
+			args:     []string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt", "--hsts", "none"},
+			expected: andrew.SecurityInfo{Headers: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		opts, _, err := andrew.ParseOptions(tc.args, new(bytes.Buffer))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tc.expected, *opts.SecurityInfo); diff != "" {
+			t.Errorf("%v: %s", tc.args, diff)
+		}
+	}
+}
+
+func TestParseOptsReadsSymlinkPolicy(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.SymlinkPolicy != andrew.SymlinksWithinRoot {
+		t.Errorf("expected the default policy %q, received %q", andrew.SymlinksWithinRoot, opts.SymlinkPolicy)
+	}
+
+	opts, _, err = andrew.ParseOptions([]string{"--symlinks", "deny"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.SymlinkPolicy != andrew.SymlinksDeny {
+		t.Errorf("expected %q, received %q", andrew.SymlinksDeny, opts.SymlinkPolicy)
+	}
+
+	requireExitWithErrorContaining(t, "--symlinks must be one of", []string{"--symlinks", "sometimes"})
+}
+
+func TestParseOptsReadsAcmeOptions(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{"--acme", "--acme-email", "me@example.com", "--acme-cache", "/var/cache/andrew",
+		"--acme-directory", "https://localhost:14000/dir", "--acme-ca-cert", "testdata/acme-ca.pem", "--acme-http-address", ":5002"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := andrew.AcmeInfo{
+		Email:        "me@example.com",
+		CacheDir:     "/var/cache/andrew",
+		DirectoryURL: "https://localhost:14000/dir",
+		CACertPath:   "testdata/acme-ca.pem",
+		HTTPAddress:  ":5002",
+	}
+
+	if opts.CertInfo == nil || opts.CertInfo.Acme == nil {
+		t.Fatal("expected --acme to get certificates with ACME")
+	}
+
+	if diff := cmp.Diff(expected, *opts.CertInfo.Acme); diff != "" {
+		t.Error(diff)
+	}
+
+	if !opts.SecurityInfo.Headers {
+		t.Error("expected --acme to turn security headers on, as it serves https")
+	}
+
+	opts, _, err = andrew.ParseOptions([]string{"--acme"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.CertInfo.Acme.DirectoryURL != andrew.DefaultAcmeDirectory || opts.CertInfo.Acme.HTTPAddress != andrew.DefaultAcmeHTTPAddress {
+		t.Errorf("expected the default directory and http address, received %+v", *opts.CertInfo.Acme)
+	}
+
+	if opts.CertInfo.Acme.CacheDir == "" {
+		t.Error("expected a default cache directory")
+	}
+}
+
+func TestMainCalledWithAcmeAndCertExitsWithAnError(t *testing.T) {
+	t.Parallel()
+
+	requireExitWithErrorContaining(t, "can't be used with --cert", []string{"--acme", "--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt"})
+	requireExitWithErrorContaining(t, "missing value after --acme-email", []string{"--acme-email"})
+	requireExitWithErrorContaining(t, "file does not exist", []string{"--acme-ca-cert", "testdata/missing.pem"})
+}
+
+func TestMainCalledWithAcmeAndAnHttpBaseUrlExitsWithAnError(t *testing.T) {
+	t.Parallel()
+
+	requireExitWithErrorContaining(t, "--acme needs an https:// baseUrl", []string{"--acme", "--acme-cache", t.TempDir(), "--acme-http-address", "", t.TempDir(), "localhost:0", "http://example.com"})
+}
+
+func TestParseOptsReadsHTTPAddress(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt",
+		"--http-address", ":80", "--http-health-check"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.HTTPAddress != ":80" || !opts.HTTPHealthCheck {
+		t.Errorf("expected to listen for http on :80 with health checks, received %q and %t", opts.HTTPAddress, opts.HTTPHealthCheck)
+	}
+
+	requireExitWithErrorContaining(t, "--http-address redirects to https", []string{"--http-address", ":80"})
+}
+
+func TestParseOptsReadsTLSOptions(t *testing.T) {
+	t.Parallel()
+
+	opts, _, err := andrew.ParseOptions([]string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt",
+		"--tls-min-version", "1.3",
+		"--tls-ciphers", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"--tls-curves", "X25519,P-256",
+		"--client-ca", "testdata/acme-ca.pem",
+		"--client-cert-path", "/drafts/",
+		"--client-cert-path", "/private/",
+	}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	fn()
+	expected := andrew.TLSInfo{
+		MinVersion:      tls.VersionTLS13,
+		CipherSuites:    []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		Curves:          []tls.CurveID{tls.X25519, tls.CurveP256},
+		ClientCAPath:    "testdata/acme-ca.pem",
+		ClientCertPaths: []string{"/drafts/", "/private/"},
+	}
+
+	if diff := cmp.Diff(expected, *opts.TLSInfo); diff != "" {
+		t.Error(diff)
+	}
+
+	opts, _, err = andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.TLSInfo.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 at least by default, received %x", opts.TLSInfo.MinVersion)
+	}
+}
+
+func TestMainCalledWithBadTLSOptionsExitsWithAnError(t *testing.T) {
+	t.Parallel()
+
+	requireExitWithErrorContaining(t, "must be 1.2 or 1.3", []string{"--tls-min-version", "1.0"})
+	requireExitWithErrorContaining(t, "isn't a cipher suite", []string{"--tls-ciphers", "TLS_RSA_WITH_RC4_128_SHA"})
+	requireExitWithErrorContaining(t, "TLS 1.3 cipher suite", []string{"--tls-ciphers", "TLS_AES_128_GCM_SHA256"})
+	requireExitWithErrorContaining(t, "--tls-curves must be from", []string{"--tls-curves", "P-224"})
+	requireExitWithErrorContaining(t, "needs --client-ca", []string{"--cert", "testdata/test-cert.crt", "--privatekey", "testdata/test-cert.crt", "--client-cert-path", "/drafts/"})
+	requireExitWithErrorContaining(t, "needs https", []string{"--client-ca", "testdata/acme-ca.pem", "--client-cert-path", "/drafts/"})
+}
+
+func TestParseOptsStillReturnsCertAndRssInfo(t *testing.T) {
+	t.Parallel()
+
+	cert, rss, remaining, err := andrew.ParseOpts([]string{"--cert", "testdata/localhost.crt", "--privatekey", "testdata/localhost.key", "--rsstitle", "Mine", "./site"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert == nil || cert.CertPath != "testdata/localhost.crt" || cert.PrivateKeyPath != "testdata/localhost.key" {
+		t.Errorf("expected the certificate and key, received %+v", cert)
+	}
+
+	if rss.Title != "Mine" {
+		t.Errorf("expected the rss title Mine, received %q", rss.Title)
+	}
+
+	if diff := cmp.Diff([]string{"./site"}, remaining); diff != "" {
+		t.Error(diff)
+	}
+
+	if _, _, _, err := andrew.ParseOpts([]string{"--max-partial-depth", "0"}, new(bytes.Buffer)); err == nil {
+		t.Error("expected the options it doesn't return to be checked all the same")
+	}
 }
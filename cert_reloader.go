@@ -0,0 +1,137 @@
+package andrew
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certCheckInterval is how often a handshake checks whether the certificate or key files
+// have changed.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate and key at certPath and privateKeyPath, and swaps in
+// the new pair whenever either file changes or Andrew gets a SIGHUP, so a certificate
+// renewed by certbot is served without restarting Andrew. A pair that can't be loaded, like
+// a certificate that's been replaced but whose key hasn't yet, is logged, and the old pair
+// kept until a good one turns up.
+type certReloader struct {
+	certPath       string
+	privateKeyPath string
+	checkInterval  time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certVersion fileVersion
+	keyVersion  fileVersion
+	lastChecked time.Time
+}
+
+// newCertReloader loads the certificate and key. Unlike a reload, failing to load them is
+// an error: there's no old pair to keep serving.
+func newCertReloader(certPath string, privateKeyPath string) (*certReloader, error) {
+	r := &certReloader{certPath: certPath, privateKeyPath: privateKeyPath, checkInterval: certCheckInterval}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate is for tls.Config.GetCertificate. It checks the files for changes at most
+// every checkInterval.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	check := time.Since(r.lastChecked) > r.checkInterval
+	r.mu.Unlock()
+
+	if check {
+		r.reloadIfChanged()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cert, nil
+}
+
+// reloadIfChanged reloads the pair if either file has changed since it was last loaded.
+func (r *certReloader) reloadIfChanged() {
+	r.mu.Lock()
+	r.lastChecked = time.Now()
+	changed := !osFileVersion(r.certPath).same(r.certVersion) || !osFileVersion(r.privateKeyPath).same(r.keyVersion)
+	r.mu.Unlock()
+
+	if changed {
+		if err := r.reload(); err != nil {
+			slog.Warn("keeping the old certificate", "error", err)
+		}
+	}
+}
+
+// reload loads the pair and swaps it in for the old one.
+func (r *certReloader) reload() error {
+	certVersion, keyVersion := osFileVersion(r.certPath), osFileVersion(r.privateKeyPath)
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("loading certificate %s and key %s: %w", r.certPath, r.privateKeyPath, err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("reading certificate %s: %w", r.certPath, err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert, r.certVersion, r.keyVersion = &cert, certVersion, keyVersion
+	r.mu.Unlock()
+
+	tlsCertExpiry.WithLabelValues(r.certPath).Set(float64(leaf.NotAfter.Unix()))
+	slog.Info("loaded certificate", "path", r.certPath, "subject", leaf.Subject.String(), "expires", leaf.NotAfter)
+
+	return nil
+}
+
+// reloadOnSIGHUP reloads the pair whenever Andrew gets a SIGHUP, whether the files look
+// changed or not. It returns a func to stop listening for them.
+func (r *certReloader) reloadOnSIGHUP() (stop func()) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hangups:
+				if err := r.reload(); err != nil {
+					slog.Warn("keeping the old certificate", "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(hangups)
+			close(done)
+		})
+	}
+}
+
+// osFileVersion is the version of the file at filePath, which is outside the site.
+func osFileVersion(filePath string) fileVersion {
+	return currentFileVersion(os.DirFS(filepath.Dir(filePath)), filepath.Base(filePath))
+}
@@ -0,0 +1,178 @@
+package andrew
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeTestCertificate writes a self-signed certificate for commonName, and its key, to
+// cert.pem and key.pem in dir.
+func writeTestCertificate(t *testing.T, dir string, commonName string, notAfter time.Time) (certPath string, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func servedCommonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertReloaderSwapsInChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir, "old.example.com", time.Now().Add(24*time.Hour))
+
+	r, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.checkInterval = 0
+
+	if name := servedCommonName(t, r); name != "old.example.com" {
+		t.Errorf("expected old.example.com, received %s", name)
+	}
+
+	expiry := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	writeTestCertificate(t, dir, "new.example.com", expiry)
+
+	if name := servedCommonName(t, r); name != "new.example.com" {
+		t.Errorf("expected the renewed new.example.com, received %s", name)
+	}
+
+	if got := testutil.ToFloat64(tlsCertExpiry.WithLabelValues(certPath)); got != float64(expiry.Unix()) {
+		t.Errorf("expected andrew_tls_cert_expiry_seconds to be %d, received %f", expiry.Unix(), got)
+	}
+}
+
+func TestCertReloaderKeepsTheOldPairWhenTheNewOneIsBroken(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir, "old.example.com", time.Now().Add(24*time.Hour))
+
+	r, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.checkInterval = 0
+
+	// A certificate replaced before its key.
+	if err := os.WriteFile(keyPath, []byte("not yet"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if name := servedCommonName(t, r); name != "old.example.com" {
+		t.Errorf("expected old.example.com to be kept, received %s", name)
+	}
+
+	writeTestCertificate(t, dir, "new.example.com", time.Now().Add(24*time.Hour))
+
+	if name := servedCommonName(t, r); name != "new.example.com" {
+		t.Errorf("expected new.example.com once the pair was whole, received %s", name)
+	}
+}
+
+func TestCertReloaderOnlyChecksTheFilesEveryInterval(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir, "old.example.com", time.Now().Add(24*time.Hour))
+
+	r, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.checkInterval = time.Hour
+	r.lastChecked = time.Now()
+
+	writeTestCertificate(t, dir, "new.example.com", time.Now().Add(24*time.Hour))
+
+	if name := servedCommonName(t, r); name != "old.example.com" {
+		t.Errorf("expected old.example.com until the next check, received %s", name)
+	}
+}
+
+func TestCertReloaderReloadsOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCertificate(t, dir, "old.example.com", time.Now().Add(24*time.Hour))
+
+	r, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.checkInterval = time.Hour
+	r.lastChecked = time.Now()
+
+	stop := r.reloadOnSIGHUP()
+	t.Cleanup(stop)
+
+	writeTestCertificate(t, dir, "new.example.com", time.Now().Add(24*time.Hour))
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedCommonName(t, r) != "new.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a SIGHUP to load new.example.com")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewCertReloaderFailsWithoutACertificate(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newCertReloader(filepath.Join(t.TempDir(), "missing.pem"), "testdata/acme-ca.pem"); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+}
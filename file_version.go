@@ -0,0 +1,30 @@
+package andrew
+
+import (
+	"io/fs"
+	"time"
+)
+
+// fileVersion is enough about a file to tell whether it has changed, for the files in the
+// root of a site, like _redirects, that Andrew reads again when they change rather than
+// needing a restart.
+type fileVersion struct {
+	found   bool
+	modTime time.Time
+	size    int64
+}
+
+// currentFileVersion is the version of name in siteFiles as it is now.
+func currentFileVersion(siteFiles fs.FS, name string) fileVersion {
+	info, err := fs.Stat(siteFiles, name)
+	if err != nil {
+		return fileVersion{}
+	}
+
+	return fileVersion{found: true, modTime: info.ModTime(), size: info.Size()}
+}
+
+// same reports whether two versions are of the same file content, or both of a missing file.
+func (v fileVersion) same(other fileVersion) bool {
+	return v.found == other.found && v.modTime.Equal(other.modTime) && v.size == other.size
+}
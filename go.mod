@@ -5,6 +5,8 @@ go 1.23
 require (
 	github.com/google/go-cmp v0.6.0
 	github.com/prometheus/client_golang v1.20.4
+	github.com/yuin/goldmark v1.7.8
+	golang.org/x/crypto v0.24.0
 	golang.org/x/net v0.26.0
 )
 
@@ -12,10 +14,12 @@ require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/prometheus/client_model v0.6.1 // indirect
 	github.com/prometheus/common v0.55.0 // indirect
 	github.com/prometheus/procfs v0.15.1 // indirect
 	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 )
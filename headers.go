@@ -0,0 +1,222 @@
+package andrew
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// headersFile is the file in the root of the site that sets response headers by path:
+//
+//	/assets/*
+//	  Cache-Control: public, max-age=604800
+//	  Access-Control-Allow-Origin: *
+//
+// A path pattern starts at the beginning of a line, and the headers for it follow on
+// indented lines. A * in a pattern matches anything, slashes included. Blank lines and lines
+// starting with # are skipped.
+const headersFile = "_headers"
+
+// immutableCacheControl is the default Cache-Control for fingerprinted assets, like
+// app.3f9a2c1b.css: their content can't change without their name changing too, so they can
+// be cached for as long as a browser will keep them.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// documentCacheControl is the default Cache-Control for pages, the rss feed and the sitemap,
+// which can change at any time.
+const documentCacheControl = "public, max-age=300"
+
+// fingerprintFinder matches the names of fingerprinted assets: a hash of at least 8 hex
+// digits just before the extension, after a . or a -.
+var fingerprintFinder = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[^./]+$`)
+
+// headerRule is a path pattern from the headers file and the headers it sets.
+type headerRule struct {
+	pattern *regexp.Regexp
+	headers http.Header
+}
+
+// headerTable is the rules from a site's headers file, read again whenever the file changes.
+// Its zero value is ready to use.
+type headerTable struct {
+	mu      sync.Mutex
+	version fileVersion
+	rules   []headerRule
+}
+
+// load reads the headers file, whether it's changed or not.
+func (t *headerTable) load(siteFiles fs.FS) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.loadRules(siteFiles)
+}
+
+// headersFor is every header the headers file sets for urlPath. Every rule whose pattern
+// matches applies, and a header set by more than one of them is taken from the last.
+func (t *headerTable) headersFor(siteFiles fs.FS, urlPath string) http.Header {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !currentFileVersion(siteFiles, headersFile).same(t.version) {
+		t.loadRules(siteFiles)
+	}
+
+	headers := http.Header{}
+	for _, rule := range t.rules {
+		if !rule.pattern.MatchString(urlPath) {
+			continue
+		}
+
+		for name, values := range rule.headers {
+			headers[name] = values
+		}
+	}
+
+	return headers
+}
+
+func (t *headerTable) loadRules(siteFiles fs.FS) {
+	t.rules, t.version = nil, currentFileVersion(siteFiles, headersFile)
+
+	if !t.version.found {
+		return
+	}
+
+	content, err := fs.ReadFile(siteFiles, headersFile)
+	if err != nil {
+		slog.Error("headers could not be read", "path", headersFile, "error", err)
+		return
+	}
+
+	t.rules = parseHeaders(content)
+
+	slog.Info("headers loaded", "path", headersFile, "rules", len(t.rules))
+}
+
+// parseHeaders reads the rules from a headers file. A line that can't be understood is
+// logged and skipped.
+func parseHeaders(content []byte) []headerRule {
+	rules := []headerRule{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == line {
+			if !strings.HasPrefix(line, "/") {
+				slog.Warn("skipping header rule", "path", headersFile, "line", n, "error", fmt.Errorf("%q must start with a /", line))
+				continue
+			}
+
+			rules = append(rules, headerRule{pattern: globPattern(line), headers: http.Header{}})
+			continue
+		}
+
+		name, value, found := strings.Cut(trimmed, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		switch {
+		case len(rules) == 0:
+			slog.Warn("skipping header", "path", headersFile, "line", n, "error", fmt.Errorf("%q isn't under a path", trimmed))
+		case !found || name == "":
+			slog.Warn("skipping header", "path", headersFile, "line", n, "error", fmt.Errorf("%q isn't a Name: value header", trimmed))
+		default:
+			rules[len(rules)-1].headers.Add(name, value)
+		}
+	}
+
+	return rules
+}
+
+// globPattern turns a headers file path pattern into a regular expression matching the
+// whole path.
+func globPattern(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`) + "$")
+}
+
+// defaultHeaders is what Andrew sets on a successful response for r when neither the handler
+// nor the headers file says otherwise.
+func defaultHeaders(r *http.Request) http.Header {
+	urlPath := path.Clean("/" + r.URL.Path)
+
+	switch {
+	case fingerprintFinder.MatchString(path.Base(urlPath)):
+		return http.Header{"Cache-Control": {immutableCacheControl}}
+	case pageOrAsset(r) == "page", urlPath == "/rss.xml", siteMapPartFinder.MatchString(strings.TrimPrefix(urlPath, "/")), urlPath == "/sitemap.xml":
+		return http.Header{"Cache-Control": {documentCacheControl}}
+	}
+
+	return http.Header{}
+}
+
+// withHeaders sets the security headers, the default headers and the headers file's headers
+// on next's responses.
+func (a *Server) withHeaders(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.setSecurityHeaders(w, r)
+
+		next(&headerWriter{
+			ResponseWriter: w,
+			defaults:       defaultHeaders(r),
+			custom:         a.shared().headers.headersFor(a.SiteFiles, path.Clean("/"+r.URL.Path)),
+		}, r)
+	}
+}
+
+// headerWriter adds headers to a response just before its status is written, when it's
+// known whether the response is a success. The defaults only go on successful responses,
+// so a 404 for a fingerprinted asset isn't cached forever; the headers file's go on every
+// response, and win over the handler's own.
+type headerWriter struct {
+	http.ResponseWriter
+	defaults    http.Header
+	custom      http.Header
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		header := w.Header()
+		if status < http.StatusMultipleChoices || status == http.StatusNotModified {
+			for name, values := range w.defaults {
+				if header.Get(name) == "" {
+					header[name] = values
+				}
+			}
+		}
+
+		for name, values := range w.custom {
+			header[name] = values
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter.
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
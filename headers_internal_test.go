@@ -0,0 +1,67 @@
+package andrew
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseHeaders(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`# a comment
+  X-Orphan: no path above me
+/assets/*
+  Cache-Control: public, max-age=604800
+  Link: </style.css>; rel=preload
+  Link: </app.js>; rel=preload
+  not a header
+relative/*
+/*.html
+  X-Frame-Options: DENY
+`)
+
+	rules := parseHeaders(content)
+
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, received %d", len(rules))
+	}
+
+	expected := http.Header{
+		"Cache-Control": {"public, max-age=604800"},
+		"Link":          {"</style.css>; rel=preload", "</app.js>; rel=preload"},
+	}
+	if diff := cmp.Diff(expected, rules[0].headers); diff != "" {
+		t.Error(diff)
+	}
+
+	for urlPath, matches := range map[string]bool{"/index.html": true, "/blog/post.html": true, "/style.css": false} {
+		if rules[1].pattern.MatchString(urlPath) != matches {
+			t.Errorf("expected /*.html matching %s to be %t", urlPath, matches)
+		}
+	}
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"/":                         documentCacheControl,
+		"/blog/post.html":           documentCacheControl,
+		"/rss.xml":                  documentCacheControl,
+		"/sitemap-2.xml":            documentCacheControl,
+		"/assets/app.3f9a2c1b.css":  immutableCacheControl,
+		"/assets/app-3f9a2c1b8e.js": immutableCacheControl,
+		"/assets/app.css":           "",
+		"/robots.txt":               "",
+	}
+
+	for urlPath, expected := range testCases {
+		received := defaultHeaders(httptest.NewRequest(http.MethodGet, urlPath, nil)).Get("Cache-Control")
+		if received != expected {
+			t.Errorf("%s: expected %q, received %q", urlPath, expected, received)
+		}
+	}
+}
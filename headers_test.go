@@ -0,0 +1,78 @@
+package andrew_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func requireHeader(t *testing.T, url string, name string, expected string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if received := resp.Header.Get(name); received != expected {
+		t.Errorf("%s: expected %s %q, received %q", url, name, expected, received)
+	}
+}
+
+func TestServeSetsHeadersFromTheHeadersFile(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"_headers": {Data: []byte(`/*
+  X-Robots-Tag: noarchive
+/fonts/*
+  Access-Control-Allow-Origin: *
+/blog/*
+  Cache-Control: no-cache
+`)},
+		"index.html":              {Data: []byte(`<title>home</title>`)},
+		"blog/post.html":          {Data: []byte(`<title>post</title>`)},
+		"fonts/serif.woff2":       {Data: []byte(`font`)},
+		"assets/app.3f9a2c1b.css": {Data: []byte(`body {}`)},
+	})
+
+	requireHeader(t, s.BaseUrl+"/index.html", "X-Robots-Tag", "noarchive")
+	requireHeader(t, s.BaseUrl+"/index.html", "Cache-Control", "public, max-age=300")
+	requireHeader(t, s.BaseUrl+"/blog/post.html", "Cache-Control", "no-cache")
+	requireHeader(t, s.BaseUrl+"/fonts/serif.woff2", "Access-Control-Allow-Origin", "*")
+	requireHeader(t, s.BaseUrl+"/assets/app.3f9a2c1b.css", "Cache-Control", "public, max-age=31536000, immutable")
+	requireHeader(t, s.BaseUrl+"/sitemap.xml", "X-Robots-Tag", "noarchive")
+	requireHeader(t, s.BaseUrl+"/rss.xml", "Cache-Control", "public, max-age=300")
+}
+
+func TestServeDoesNotCacheErrorsForever(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{})
+
+	requireHeader(t, s.BaseUrl+"/assets/app.3f9a2c1b.css", "Cache-Control", "")
+}
+
+func TestServeReloadsTheHeadersFileWhenItChanges(t *testing.T) {
+	t.Parallel()
+
+	contentRoot := t.TempDir()
+	headers := filepath.Join(contentRoot, "_headers")
+
+	if err := os.WriteFile(headers, []byte("/*\n  X-Version: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestAndrewServer(t, os.DirFS(contentRoot))
+
+	requireHeader(t, s.BaseUrl+"/", "X-Version", "1")
+
+	if err := os.WriteFile(headers, []byte("/*\n  X-Version: 22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	requireHeader(t, s.BaseUrl+"/", "X-Version", "22")
+}
@@ -0,0 +1,76 @@
+package andrew
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// wellKnownDir is the one dotted directory that's served: it's where ACME challenges,
+// security.txt and the like are expected to be.
+const wellKnownDir = ".well-known"
+
+// siteConfigFiles are the files in the root of the site that configure Andrew rather than
+// being part of the site.
+var siteConfigFiles = []string{redirectsFile, headersFile, ignoreFile}
+
+// isHidden reports whether the file at pagePath, relative to the root of the site, must not
+// be served. Hidden files are answered with a 404, the same as files that don't exist, so
+// there's no telling them apart from outside.
+//
+// Hidden are: dotted paths like .git/config and .env, apart from .well-known, unless
+// ServeDotfiles says otherwise; partial and layout files, unless ServePartials says otherwise;
+// editor swap and backup files; the site's _redirects, _headers and .andrewignore; and
+// anything the site's .andrewignore matches. A page rendered from Markdown is hidden when its
+// Markdown file would be.
+func (a *Server) isHidden(pagePath string) bool {
+	if slices.Contains(siteConfigFiles, pagePath) || isEditorFile(pagePath) {
+		return true
+	}
+
+	if !a.ServePartials && isTemplateFile(pagePath) {
+		return true
+	}
+
+	if !a.ServeDotfiles && isDotPath(pagePath) && !isWellKnownPath(pagePath) {
+		return true
+	}
+
+	ignored := a.shared().ignores.current(a.SiteFiles)
+	if ignored.ignores(pagePath, false) {
+		return true
+	}
+
+	// A Markdown page is found from post.html, post and post/, so ignoring post.md hides
+	// all of them.
+	if source, ok := markdownSourceFor(a.SiteFiles, pagePath); ok {
+		return ignored.ignores(source, false)
+	}
+
+	return false
+}
+
+// isEditorFile reports whether a file is one an editor leaves lying around: vim's .swp and
+// .swo, emacs's #autosave# and the ~ of a backup.
+func isEditorFile(filePath string) bool {
+	name := path.Base(filePath)
+
+	switch {
+	case strings.HasSuffix(name, "~"), strings.HasSuffix(name, ".swp"), strings.HasSuffix(name, ".swo"):
+		return true
+	case len(name) > 1 && strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"):
+		return true
+	}
+
+	return false
+}
+
+// isTemplateFile reports whether a file is only there to be put into pages: a partial, or a
+// directory's default layout.
+func isTemplateFile(filePath string) bool {
+	return isPartialFile(filePath) || path.Base(filePath) == defaultLayoutName
+}
+
+func isWellKnownPath(filePath string) bool {
+	return filePath == wellKnownDir || strings.HasPrefix(filePath, wellKnownDir+"/")
+}
@@ -0,0 +1,130 @@
+package andrew_test
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/playtechnique/andrew"
+)
+
+func requireStatus(t *testing.T, url string, expected int) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		t.Errorf("%s: expected status %d, received %d", url, expected, resp.StatusCode)
+	}
+}
+
+func hiddenFilesSite() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":                 {Data: []byte(`<title>home</title>`)},
+		".git/config":                {Data: []byte(`[core]`)},
+		".env":                       {Data: []byte(`SECRET=1`)},
+		".well-known/security.txt":   {Data: []byte(`Contact: mailto:me@example.com`)},
+		".AndrewPartialFileNav.html": {Data: []byte(`<nav></nav>`)},
+		"blog/.AndrewLayout.html":    {Data: []byte(`{{ .AndrewContent }}`)},
+		"blog/post.html~":            {Data: []byte(`backup`)},
+		"blog/.post.html.swp":        {Data: []byte(`swap`)},
+		"#post.html#":                {Data: []byte(`autosave`)},
+		"_redirects":                 {Data: []byte(`/old.html /index.html`)},
+		"_headers":                   {Data: []byte("/*\n  X-Test: 1\n")},
+		".andrewignore":              {Data: []byte("drafts/\n*.psd\nnotes.md\n")},
+		"drafts/post.html":           {Data: []byte(`<title>draft</title>`)},
+		"art/cover.psd":              {Data: []byte(`layers`)},
+		"notes.md":                   {Data: []byte(`# notes`)},
+		"blog/published.html":        {Data: []byte(`<title>published</title>`)},
+	}
+}
+
+func TestServeAnswersHiddenFilesWithNotFound(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, hiddenFilesSite())
+
+	for _, hidden := range []string{
+		"/.git/config",
+		"/.env",
+		"/.AndrewPartialFileNav.html",
+		"/blog/.AndrewLayout.html",
+		"/blog/post.html~",
+		"/blog/.post.html.swp",
+		"/%23post.html%23",
+		"/_redirects",
+		"/_headers",
+		"/.andrewignore",
+		"/drafts/post.html",
+		"/drafts/",
+		"/art/cover.psd",
+		"/notes.html",
+		"/notes.md",
+	} {
+		requireStatus(t, s.BaseUrl+hidden, http.StatusNotFound)
+	}
+
+	requireStatus(t, s.BaseUrl+"/.well-known/security.txt", http.StatusOK)
+	requireStatus(t, s.BaseUrl+"/blog/published.html", http.StatusOK)
+}
+
+func TestServeHidesAnIgnoredMarkdownPageAtEachOfItsAddresses(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, hiddenFilesSite())
+
+	// Not even a redirect to notes.html, which would give away that it's there.
+	for _, hidden := range []string{"/notes", "/notes/", "/notes.html"} {
+		resp, err := noRedirectClient.Get(s.BaseUrl + hidden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, received %d", hidden, resp.StatusCode)
+		}
+	}
+}
+
+func TestServeCanServeDotfilesAndPartials(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, hiddenFilesSite())
+	s.ServeDotfiles = true
+
+	requireStatus(t, s.BaseUrl+"/.env", http.StatusOK)
+	requireStatus(t, s.BaseUrl+"/.AndrewPartialFileNav.html", http.StatusNotFound)
+	requireStatus(t, s.BaseUrl+"/_redirects", http.StatusNotFound)
+
+	s = newTestAndrewServer(t, hiddenFilesSite())
+	s.ServeDotfiles = true
+	s.ServePartials = true
+
+	requireStatus(t, s.BaseUrl+"/.AndrewPartialFileNav.html", http.StatusOK)
+}
+
+func TestIgnoredPagesAreNotListed(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: hiddenFilesSite()}
+
+	siblings, err := server.GetSiblingsAndChildren("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, sibling := range siblings {
+		got = append(got, sibling.UrlPath)
+	}
+
+	if diff := cmp.Diff([]string{"blog/published.html"}, got); diff != "" {
+		t.Error(diff)
+	}
+}
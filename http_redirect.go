@@ -0,0 +1,74 @@
+package andrew
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// healthCheckPath is where the plain http listener answers health checks, when asked to.
+const healthCheckPath = "/healthz"
+
+// httpsRedirect answers plain http requests by redirecting them to the same path and query
+// on the site's https address, so links and bookmarks to http:// keep working. With
+// HTTPHealthCheck, it answers health checks at /healthz itself, for load balancers that
+// only check over http.
+func (a *Server) httpsRedirect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.HTTPHealthCheck && r.URL.Path == healthCheckPath {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.Write([]byte("ok\n"))
+			return
+		}
+
+		target := url.URL{Scheme: "https", Host: a.httpsHost(r), Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	}
+}
+
+// httpsHost is the host, and port if it has one, of the site's https address: the one in
+// BaseUrl. If BaseUrl doesn't have one, it's the host the request was for, on the default
+// https port.
+func (a *Server) httpsHost(r *http.Request) string {
+	if base, err := url.Parse(a.BaseUrl); err == nil && base.Host != "" {
+		return base.Host
+	}
+
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+
+	return r.Host
+}
+
+// listenForHTTP starts the plain http listener at address, answering with handler, for as
+// long as the https server runs.
+func (a *Server) listenForHTTP(address string, handler http.Handler) {
+	a.redirectServer = startHTTPServer(address, handler, a.SocketMode)
+}
+
+// startHTTPServer serves plain http at address in the background. It's for the listeners
+// that serve alongside https, which can't stop https from being served, so failing to listen
+// is logged.
+func startHTTPServer(address string, handler http.Handler, socketMode fs.FileMode) *http.Server {
+	server := &http.Server{Addr: address, Handler: handler}
+
+	listener, err := listen(address, socketMode)
+	if err != nil {
+		slog.Warn("not listening for http", "address", address, "error", err)
+		return server
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("not listening for http", "address", address, "error", err)
+		}
+	}()
+
+	return server
+}
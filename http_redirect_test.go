@@ -0,0 +1,134 @@
+package andrew_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+// freeAddress is a localhost address with a port nothing is listening on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// newTestHttpsServer starts a server for siteFiles serving https with testdata/localhost.crt,
+// and listening for plain http at its HTTPAddress.
+func newTestHttpsServer(t *testing.T, siteFiles fstest.MapFS, configure func(*andrew.Server)) *andrew.Server {
+	t.Helper()
+
+	address := freeAddress(t)
+	s := andrew.NewServer(siteFiles, address, "https://"+address, andrew.RssInfo{Dir: "."})
+	s.HTTPAddress = freeAddress(t)
+	configure(s)
+	t.Cleanup(func() { s.Close() })
+
+	go func() {
+		err := andrew.ListenAndServe(s, &andrew.CertInfo{CertPath: "testdata/localhost.crt", PrivateKeyPath: "testdata/localhost.key"})
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Log("Server stopped with error:", err)
+		}
+	}()
+
+	for _, addr := range []string{s.Address, s.HTTPAddress} {
+		for i := 0; ; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if i == 20 {
+				t.Fatalf("nothing listening on %s", addr)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	return s
+}
+
+func TestHttpIsRedirectedToHttps(t *testing.T) {
+	t.Parallel()
+
+	s := newTestHttpsServer(t, fstest.MapFS{"blog/index.html": {Data: []byte(`<title>blog</title>`)}}, func(*andrew.Server) {})
+
+	requireRedirect(t, "http://"+s.HTTPAddress+"/blog/?page=2", http.StatusMovedPermanently, s.BaseUrl+"/blog/?page=2")
+	requireRedirect(t, "http://"+s.HTTPAddress+"/my%20page.html", http.StatusMovedPermanently, s.BaseUrl+"/my%20page.html")
+
+	// The health check is only answered when it's asked for.
+	requireRedirect(t, "http://"+s.HTTPAddress+"/healthz", http.StatusMovedPermanently, s.BaseUrl+"/healthz")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(s.BaseUrl + "/blog/?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d over https, received %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestHttpAnswersHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	s := newTestHttpsServer(t, fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, func(s *andrew.Server) {
+		s.HTTPHealthCheck = true
+	})
+
+	resp, err := noRedirectClient.Get("http://" + s.HTTPAddress + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok\n" {
+		t.Errorf("expected a 200 saying ok, received %d saying %q", resp.StatusCode, body)
+	}
+
+	requireRedirect(t, "http://"+s.HTTPAddress+"/", http.StatusMovedPermanently, s.BaseUrl+"/")
+}
+
+func TestHttpsSendsHSTS(t *testing.T) {
+	t.Parallel()
+
+	s := newTestHttpsServer(t, fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, func(s *andrew.Server) {
+		s.SecurityInfo = andrew.SecurityInfo{HSTS: "max-age=300"}
+	})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(s.BaseUrl + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != "max-age=300" {
+		t.Errorf("expected Strict-Transport-Security %q, received %q", "max-age=300", hsts)
+	}
+
+	// HSTS on its own doesn't turn on the other security headers.
+	if nosniff := resp.Header.Get("X-Content-Type-Options"); nosniff != "" {
+		t.Errorf("expected no X-Content-Type-Options, received %q", nosniff)
+	}
+}
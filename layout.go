@@ -0,0 +1,133 @@
+package andrew
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io/fs"
+	"regexp"
+	"text/template"
+)
+
+// layoutSlotFinder finds the places in a layout that a page's content, title and head go.
+var layoutSlotFinder = regexp.MustCompile(`{{\s*\.(AndrewContent|AndrewTitle|AndrewHead)\s*}}`)
+
+// defaultLayoutName is the layout Andrew looks for, from a page's directory upwards, when
+// the page doesn't name one.
+const defaultLayoutName = ".AndrewLayout.html"
+
+// noLayout is the andrew-layout a page uses to opt out of a directory's default layout.
+const noLayout = "none"
+
+var (
+	headFinder        = regexp.MustCompile(`(?is)<head(?:\s[^>]*)?>(.*?)</head>`)
+	bodyFinder        = regexp.MustCompile(`(?is)<body(?:\s[^>]*)?>(.*?)(?:</body>|</html>|\z)`)
+	titleFinder       = regexp.MustCompile(`(?is)<title(?:\s[^>]*)?>.*?</title>`)
+	documentTagFinder = regexp.MustCompile(`(?i)<!doctype[^>]*>|</?html(?:\s[^>]*)?>`)
+)
+
+// renderLayout wraps an html page in a layout, so the page only has to hold what's
+// particular to it. The page names its layout with
+//
+//	<meta name="andrew-layout" content="post.html">
+//
+// and without one gets .AndrewLayout.html, if there is one. Either is found by searching
+// upwards from the page, the same way partials are. A page that wants neither says
+// content="none".
+//
+// The page's body goes into the layout's {{ .AndrewContent }} slot, its title into
+// {{ .AndrewTitle }}, and everything else in its <head>, like its meta elements and
+// stylesheets, into {{ .AndrewHead }}. The layout's own partials are rendered as if they
+// were in the page, with the same data and functions.
+func renderLayout(siteFiles fs.FS, pagePath string, pageContent []byte, pageTitle string, data map[string]any, funcs template.FuncMap, maxPartialDepth int) ([]byte, error) {
+	meta, err := GetMetaElements(pageContent)
+	if err != nil {
+		return nil, err
+	}
+
+	layoutName, named := meta["andrew-layout"]
+	if layoutName == noLayout {
+		return pageContent, nil
+	}
+
+	layout, found, err := readLayout(siteFiles, pagePath, layoutName, named)
+	if err != nil || !found {
+		return pageContent, err
+	}
+
+	layout, err = renderPartialFiles(siteFiles, pagePath, layout, data, funcs, maxPartialDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	head, body := splitPage(pageContent)
+
+	return fillLayout(layout, pageTitle, head, body), nil
+}
+
+// readLayout finds and reads the layout for the page at pagePath: the one called
+// layoutName if named is true, and otherwise the default. A layout the page asked for by
+// name has to exist; the default one is optional, and found is false when there isn't one.
+// A layout page isn't wrapped in itself when it's requested directly, so that's not found
+// either.
+func readLayout(siteFiles fs.FS, pagePath string, layoutName string, named bool) ([]byte, bool, error) {
+	if !named {
+		layoutName = defaultLayoutName
+	}
+
+	layoutPath, err := findPartialFile(siteFiles, pagePath, layoutName)
+	switch {
+	case err == nil:
+	case !named && errors.Is(err, fs.ErrNotExist):
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+
+	if layoutPath == pagePath {
+		return nil, false, nil
+	}
+
+	layout, err := fs.ReadFile(siteFiles, layoutPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return layout, true, nil
+}
+
+// fillLayout puts a page into a layout's slots. The slots are filled in directly, rather
+// than by executing the layout as a template, so that any tables of contents in the layout
+// are left for the usual page rendering to take care of.
+func fillLayout(layout []byte, title string, head []byte, content []byte) []byte {
+	return layoutSlotFinder.ReplaceAllFunc(layout, func(slot []byte) []byte {
+		switch {
+		case bytes.Contains(slot, []byte("AndrewTitle")):
+			return []byte(html.EscapeString(title))
+		case bytes.Contains(slot, []byte("AndrewHead")):
+			return head
+		default:
+			return content
+		}
+	})
+}
+
+// splitPage separates an html page into what's inside its <head>, less the <title> the
+// layout takes care of, and what's inside its <body>. A page without a <body> element is
+// all body, apart from any <head> or <title> it has.
+func splitPage(pageContent []byte) ([]byte, []byte) {
+	var head []byte
+	if m := headFinder.FindSubmatch(pageContent); m != nil {
+		head = bytes.TrimSpace(titleFinder.ReplaceAll(m[1], nil))
+	}
+
+	if m := bodyFinder.FindSubmatch(pageContent); m != nil {
+		return head, bytes.TrimSpace(m[1])
+	}
+
+	body := headFinder.ReplaceAll(pageContent, nil)
+	body = documentTagFinder.ReplaceAll(body, nil)
+	body = titleFinder.ReplaceAll(body, nil)
+
+	return head, bytes.TrimSpace(body)
+}
@@ -0,0 +1,140 @@
+package andrew_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/playtechnique/andrew"
+)
+
+const testLayout = `<!DOCTYPE html>
+<html>
+<head>
+<title>{{ .AndrewTitle }} - my site</title>
+{{ .AndrewHead }}
+</head>
+<body>
+{{ .AndrewPartialFileNav.html }}
+<main>{{ .AndrewContent }}</main>
+</body>
+</html>
+`
+
+func TestPageNamingALayoutIsWrappedInIt(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"post.html":                  {Data: []byte(testLayout)},
+		".AndrewPartialFileNav.html": {Data: []byte(`<nav>home</nav>`)},
+		"blog/first.html": {Data: []byte(`<!DOCTYPE html>
+<html>
+<head>
+<title>First & best</title>
+<meta name="andrew-layout" content="post.html">
+<meta name="andrew-publish-time" content="2025-03-30">
+</head>
+<body>
+<p>Hello</p>
+</body>
+</html>
+`)},
+	}}
+
+	page, err := server.NewPage("blog/first.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<!DOCTYPE html>
+<html>
+<head>
+<title>First &amp; best - my site</title>
+<meta name="andrew-layout" content="post.html">
+<meta name="andrew-publish-time" content="2025-03-30">
+</head>
+<body>
+<nav>home</nav>
+<main><p>Hello</p></main>
+</body>
+</html>
+`
+
+	if page.Content != expected {
+		t.Errorf("expected %q, received %q", expected, page.Content)
+	}
+
+	if page.Title != "First & best" || page.Meta["andrew-publish-time"] != "2025-03-30" {
+		t.Errorf("expected the page's own title and meta, received %q and %v", page.Title, page.Meta)
+	}
+}
+
+func TestDefaultLayoutWrapsPagesBelowIt(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"blog/.AndrewLayout.html": {Data: []byte(`<div class="blog">{{ .AndrewContent }}</div>{{ .AndrewTableOfContents }}`)},
+		"blog/index.html":         {Data: []byte(`<p>The blog</p>`)},
+		"blog/2025/post.html":     {Data: []byte(`<title>A post</title><p>post</p>`)},
+		"about.html":              {Data: []byte(`<p>about</p>`)},
+	}}
+
+	index, err := server.NewPage("blog/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(index.Content, `<div class="blog"><p>The blog</p></div>`) || !strings.Contains(index.Content, `href="2025/post.html"`) {
+		t.Errorf("expected the blog layout with a table of contents, received %q", index.Content)
+	}
+
+	post, err := server.NewPage("blog/2025/post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(post.Content, `<div class="blog"><p>post</p></div>`) {
+		t.Errorf("expected the layout from the directory above, received %q", post.Content)
+	}
+
+	about, err := server.NewPage("about.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if about.Content != `<p>about</p>` {
+		t.Errorf("expected a page outside the layout's directory to be left alone, received %q", about.Content)
+	}
+}
+
+func TestPageCanOptOutOfTheDefaultLayout(t *testing.T) {
+	t.Parallel()
+
+	content := `<meta name="andrew-layout" content="none"><p>standalone</p>`
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		".AndrewLayout.html": {Data: []byte(`layout {{ .AndrewContent }}`)},
+		"landing.html":       {Data: []byte(content)},
+	}}
+
+	page, err := server.NewPage("landing.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Content != content {
+		t.Errorf("expected %q, received %q", content, page.Content)
+	}
+}
+
+func TestPageNamingAMissingLayoutIsAnError(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"page.html": {Data: []byte(`<meta name="andrew-layout" content="nowhere.html">`)},
+	}}
+
+	if _, err := server.NewPage("page.html"); err == nil {
+		t.Error("expected an error for a layout that doesn't exist")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"text/template"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // RenderTableOfContents dynamically looks through the siblings of the current page.
@@ -20,6 +22,20 @@ import (
 // 1. Array of Bytes - this is actually the html document, the "table of contents".
 // 2. error - one of several items here could error; regular expressions can fail, a template could misrender.
 func RenderTableOfContents(siblings []Page, startingPage Page) ([]byte, error) {
+	return renderPageTemplate(siblings, startingPage, templateData(startingPage, SiteData{}), templateFuncs(nil, SiteData{}, startingPage.UrlPath, DefaultMaxPartialDepth))
+}
+
+// tableOfContentsUser recognises a page with either kind of table of contents in it. Only
+// those pages need the pages around them read.
+var tableOfContentsUser = regexp.MustCompile(`{{\s*\.AndrewTableOfContents(?:WithDirectories)?\s*}}`)
+
+// renderPageTemplate executes a page as a template, with data as its {{ .Page }}, {{ .Site }}
+// and {{ .Meta }}, funcs as its functions, and its table of contents built from siblings if
+// it asks for one.
+// A page with neither is returned as it is.
+func renderPageTemplate(siblings []Page, startingPage Page, data map[string]any, funcs template.FuncMap) ([]byte, error) {
+	timer := prometheus.NewTimer(renderPhaseDuration.WithLabelValues("template"))
+	defer timer.ObserveDuration()
 
 	tableOfContentsFinder, err := regexp.Compile(`.*{{\s*\.AndrewTableOfContents\s*}}.*`)
 	if err != nil {
@@ -27,7 +43,7 @@ func RenderTableOfContents(siblings []Page, startingPage Page) ([]byte, error) {
 	}
 
 	if tableOfContentsFinder.FindString(startingPage.Content) != "" {
-		return renderAndrewTableOfContents(siblings, startingPage)
+		return renderAndrewTableOfContents(siblings, startingPage, data, funcs)
 	}
 
 	tableOfContentsWithDirsFinder, err := regexp.Compile(`.*{{\s*\.AndrewTableOfContentsWithDirectories\s*}}.*`)
@@ -36,12 +52,33 @@ func RenderTableOfContents(siblings []Page, startingPage Page) ([]byte, error) {
 	}
 
 	if tableOfContentsWithDirsFinder.FindString(startingPage.Content) != "" {
-		return renderAndrewTableOfContentsWithDirectories(siblings, startingPage, DefaultPageSort)
+		return renderAndrewTableOfContentsWithDirectories(siblings, startingPage, DefaultPageSort, data, funcs)
+	}
+
+	if pageDataFinder.MatchString(startingPage.Content) {
+		return executePageTemplate(startingPage, data, funcs)
 	}
 
 	return []byte(startingPage.Content), nil
 }
 
+// executePageTemplate executes the page's content as a template with data and funcs.
+func executePageTemplate(page Page, data map[string]any, funcs template.FuncMap) ([]byte, error) {
+	var templateBuffer bytes.Buffer
+
+	t, err := template.New(page.UrlPath).Funcs(funcs).Parse(page.Content)
+	if err != nil {
+		return nil, newRenderError(page.UrlPath, page.Content, err)
+	}
+
+	err = t.Execute(&templateBuffer, data)
+	if err != nil {
+		return nil, newRenderError(page.UrlPath, page.Content, err)
+	}
+
+	return templateBuffer.Bytes(), nil
+}
+
 func countSlashes(s string) int {
 	return strings.Count(s, "/")
 }
@@ -98,9 +135,8 @@ func getDirectoriesOrderedByMostRecent(dirMap map[string][]Page) []string {
 	return result
 }
 
-func renderAndrewTableOfContentsWithDirectories(siblings []Page, startingPage Page, sortFn PageSortFunc) ([]byte, error) {
+func renderAndrewTableOfContentsWithDirectories(siblings []Page, startingPage Page, sortFn PageSortFunc, data map[string]any, funcs template.FuncMap) ([]byte, error) {
 	var html bytes.Buffer
-	var templateBuffer bytes.Buffer
 	directoriesAndContents := mapFromPagePaths(siblings)
 
 	directoriesInDepthOrder := getDirectoriesOrderedByMostRecent(directoriesAndContents)
@@ -110,7 +146,7 @@ func renderAndrewTableOfContentsWithDirectories(siblings []Page, startingPage Pa
 
 	for _, parentDir := range directoriesInDepthOrder {
 		// Skip the root directory if it only contains the starting page
-		if parentDir == "" && len(directoriesAndContents[parentDir]) == 1 && directoriesAndContents[parentDir][0] == startingPage {
+		if parentDir == "" && len(directoriesAndContents[parentDir]) == 1 && directoriesAndContents[parentDir][0].UrlPath == startingPage.UrlPath {
 			continue
 		}
 
@@ -136,10 +172,10 @@ func renderAndrewTableOfContentsWithDirectories(siblings []Page, startingPage Pa
 		// Add the links to the list
 		for _, sibling := range pages {
 			// Skip the starting page
-			if sibling == startingPage {
+			if sibling.UrlPath == startingPage.UrlPath {
 				continue
 			}
-			html.Write(buildAndrewTableOfContentsLink(sibling.UrlPath, sibling.Title, sibling.PublishTime.Format(time.DateOnly), linkCount))
+			html.Write(buildAndrewTableOfContentsLink(escapeUrlPath(sibling.UrlPath), sibling.Title, sibling.PublishTime.Format(time.DateOnly), linkCount))
 			linkCount++
 		}
 
@@ -148,17 +184,7 @@ func renderAndrewTableOfContentsWithDirectories(siblings []Page, startingPage Pa
 
 	html.Write([]byte("</div>\n"))
 
-	t, err := template.New(startingPage.UrlPath).Parse(startingPage.Content)
-	if err != nil {
-		panic(err)
-	}
-
-	err = t.Execute(&templateBuffer, map[string]string{"AndrewTableOfContentsWithDirectories": html.String()})
-	if err != nil {
-		return templateBuffer.Bytes(), err
-	}
-
-	return templateBuffer.Bytes(), nil
+	return executePageTemplate(startingPage, withData(data, map[string]string{"AndrewTableOfContentsWithDirectories": html.String()}), funcs)
 }
 
 // mapFromPagePaths takes an array of pages and returns a map of those pages in which the keys
@@ -177,32 +203,18 @@ func mapFromPagePaths(siblings []Page) map[string][]Page {
 	return directoriesAndContents
 }
 
-func renderAndrewTableOfContents(siblings []Page, startingPage Page) ([]byte, error) {
+func renderAndrewTableOfContents(siblings []Page, startingPage Page, data map[string]any, funcs template.FuncMap) ([]byte, error) {
 	var html bytes.Buffer
 
 	html.Write([]byte("<div class=\"AndrewTableOfContents\">\n"))
 	html.Write([]byte("<ul>\n"))
 	for i, sibling := range siblings {
-		html.Write(buildAndrewTableOfContentsLink(sibling.UrlPath, sibling.Title, sibling.PublishTime.Format(time.DateOnly), i))
+		html.Write(buildAndrewTableOfContentsLink(escapeUrlPath(sibling.UrlPath), sibling.Title, sibling.PublishTime.Format(time.DateOnly), i))
 	}
 	html.Write([]byte("</ul>\n"))
 	html.Write([]byte("</div>\n"))
 
-	templateBuffer := bytes.Buffer{}
-
-	t, err := template.New(startingPage.UrlPath).Parse(startingPage.Content)
-
-	if err != nil {
-
-		panic(err)
-	}
-
-	err = t.Execute(&templateBuffer, map[string]string{"AndrewTableOfContents": html.String()})
-	if err != nil {
-		return templateBuffer.Bytes(), err
-	}
-
-	return templateBuffer.Bytes(), nil
+	return executePageTemplate(startingPage, withData(data, map[string]string{"AndrewTableOfContents": html.String()}), funcs)
 }
 
 // buildAndrewTableOfContentsLink creates an HTML list item containing a link to a page.
@@ -0,0 +1,208 @@
+package andrew
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// unixAddressPrefix starts the address of a unix socket, like unix:/run/andrew.sock.
+	unixAddressPrefix = "unix:"
+
+	// systemdAddress is a socket systemd opened and passed to Andrew when it started it, by
+	// socket activation. systemd:name is the one the socket unit's FileDescriptorName names.
+	systemdAddress = "systemd"
+
+	// DefaultSocketMode lets the socket's owner and group connect to a unix socket, so a
+	// proxy in Andrew's group can reach it and nobody else can.
+	DefaultSocketMode fs.FileMode = 0o660
+
+	// sdListenFdsStart is the first file descriptor systemd passes sockets in.
+	sdListenFdsStart = 3
+)
+
+// listen opens the listener for address: a unix socket with socketMode for unix:/path, a
+// socket from systemd for systemd or systemd:name, and a TCP socket for anything else.
+func listen(address string, socketMode fs.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixAddressPrefix):
+		return listenUnix(strings.TrimPrefix(address, unixAddressPrefix), socketMode)
+	case address == systemdAddress:
+		return systemdSockets.take("")
+	case strings.HasPrefix(address, systemdAddress+":"):
+		return systemdSockets.take(strings.TrimPrefix(address, systemdAddress+":"))
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// listenUnix listens on a unix socket at socketPath that only socketMode can connect to. The
+// socket is removed when the listener is closed.
+func listenUnix(socketPath string, socketMode fs.FileMode) (net.Listener, error) {
+	// An Andrew that was killed before it could close its socket leaves it behind, and it'd
+	// stop this one listening. Anything else at socketPath is left alone.
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("can't listen on %s: it's there already, and isn't a socket", socketPath)
+		}
+
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("can't listen on %s: something is already listening on it", socketPath)
+		}
+
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Made at socketPath, the socket could be connected to with whatever permissions the umask
+	// left it until it was chmodded. So it's made in a directory only Andrew can get into,
+	// given its mode there, then moved into place.
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".andrew-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	madePath := filepath.Join(dir, "socket")
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: madePath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	// The listener would remove the socket from where it was made, not from where it ends up.
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(madePath, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(madePath, socketPath); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &unixSocketListener{UnixListener: listener, path: socketPath}, nil
+}
+
+// unixSocketListener removes its socket from path when it's closed.
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+
+	closed sync.Once
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.UnixListener.Close()
+	l.closed.Do(func() { os.Remove(l.path) })
+
+	return err
+}
+
+// ParseSocketMode reads a unix socket's permissions as they're written for chmod, like 0660.
+func ParseSocketMode(mode string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("--socket-mode must be permissions in octal, like 0660, not %q", mode)
+	}
+
+	return fs.FileMode(m), nil
+}
+
+// systemdSockets are the sockets systemd passed to this process.
+var systemdSockets = &activatedSockets{getenv: os.Getenv, firstFD: sdListenFdsStart}
+
+// activatedSockets are the sockets passed in by socket activation, as systemd describes them
+// in sd_listen_fds(3). They're only looked for the first time one is asked for, and each one
+// can only be listened on once.
+type activatedSockets struct {
+	getenv  func(string) string
+	firstFD int
+
+	mu        sync.Mutex
+	loaded    bool
+	listeners []namedListener
+	err       error
+}
+
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// take is the socket called name, or the first one left when name is empty.
+func (s *activatedSockets) take(name string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		s.listeners, s.err = s.load()
+		s.loaded = true
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	names := []string{}
+	for i, l := range s.listeners {
+		if name == "" || l.name == name {
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return l.listener, nil
+		}
+		names = append(names, l.name)
+	}
+
+	if name == "" {
+		return nil, errors.New("systemd passed Andrew fewer sockets than it has addresses to listen on")
+	}
+
+	return nil, fmt.Errorf("systemd didn't pass Andrew a socket named %q; the sockets left are named %q", name, names)
+}
+
+func (s *activatedSockets) load() ([]namedListener, error) {
+	if s.getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("listening on systemd needs Andrew to be started by a systemd socket unit, which it wasn't")
+	}
+
+	count, err := strconv.Atoi(s.getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("systemd started Andrew without passing it any sockets")
+	}
+
+	names := strings.Split(s.getenv("LISTEN_FDNAMES"), ":")
+	listeners := []namedListener{}
+
+	for i := 0; i < count; i++ {
+		fd := s.firstFD + i
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		// FileListener works on its own copy of the file descriptor, so systemd's can be closed.
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("systemd's socket %d, %q, can't be listened on: %w", fd, name, err)
+		}
+
+		listeners = append(listeners, namedListener{name: name, listener: listener})
+	}
+
+	return listeners, nil
+}
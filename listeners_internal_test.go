@@ -0,0 +1,209 @@
+package andrew
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// activatedTestSockets pretends systemd passed this process a listening socket called name.
+// It returns the socket's address too.
+func activatedTestSockets(t *testing.T, name string) (*activatedSockets, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// load takes over the file descriptor it's passed and closes it, like systemd's, so it
+	// gets one of its own.
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": name,
+	}
+
+	return &activatedSockets{getenv: func(key string) string { return env[key] }, firstFD: fd}, listener.Addr().String()
+}
+
+func requireAccepts(t *testing.T, listener net.Listener, address string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+}
+
+func TestActivatedSocketsAreTakenByName(t *testing.T) {
+	t.Parallel()
+
+	sockets, address := activatedTestSockets(t, "web")
+
+	if _, err := sockets.take("http"); err == nil || !strings.Contains(err.Error(), `"web"`) {
+		t.Errorf("expected an error naming the sockets there are, received %v", err)
+	}
+
+	listener, err := sockets.take("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	requireAccepts(t, listener, address)
+
+	if _, err := sockets.take(""); err == nil {
+		t.Error("expected an error once every socket had been taken")
+	}
+}
+
+func TestActivatedSocketsAreTakenInOrder(t *testing.T) {
+	t.Parallel()
+
+	sockets, address := activatedTestSockets(t, "")
+
+	listener, err := sockets.take("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	requireAccepts(t, listener, address)
+}
+
+func TestActivatedSocketsBelongToTheProcessSystemdStarted(t *testing.T) {
+	t.Parallel()
+
+	for _, env := range []map[string]string{
+		{},
+		{"LISTEN_PID": "1", "LISTEN_FDS": "1"},
+		{"LISTEN_PID": strconv.Itoa(os.Getpid()), "LISTEN_FDS": "0"},
+	} {
+		sockets := &activatedSockets{getenv: func(key string) string { return env[key] }, firstFD: sdListenFdsStart}
+		if listener, err := sockets.take(""); err == nil {
+			listener.Close()
+			t.Errorf("%v: expected an error", env)
+		}
+	}
+}
+
+func TestServeUntilStoppedLetsRequestsInFlightFinish(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "finished")
+	})}
+
+	stop := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- serveUntilStopped(stop, func() error { return server.Serve(listener) }, server.Shutdown)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	if err := <-stopped; err != nil {
+		t.Errorf("expected a clean shutdown, received %v", err)
+	}
+
+	if body := <-responses; body != "finished" {
+		t.Errorf("expected the request in flight to finish, received %q", body)
+	}
+
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("expected nothing to be listening after shutting down")
+	}
+
+}
+
+func TestListenUnixPutsTheSocketInPlaceWithItsMode(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "andrew.sock")
+
+	listener, err := listenUnix(socketPath, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a socket with mode 600, received %s", info.Mode())
+	}
+
+	// Nothing is left behind from making it.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "andrew.sock" {
+		t.Errorf("expected only andrew.sock in the socket's directory, received %v", entries)
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected to connect to the socket where it was moved to: %v", err)
+	}
+	conn.Close()
+
+	listener.Close()
+	listener.Close()
+
+	if _, err := os.Stat(socketPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the socket to be removed when the listener closed, received %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package andrew_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+// unixClient sends every request to the unix socket at socketPath, whatever its URL says.
+func unixClient(socketPath string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+}
+
+func waitForSocket(t *testing.T, socketPath string) {
+	t.Helper()
+
+	for i := 0; ; i++ {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if i == 20 {
+			t.Fatalf("nothing listening on %s", socketPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestServerListensOnAUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "andrew.sock")
+
+	s := andrew.NewServer(fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, "unix:"+socketPath, "https://www.example.com", andrew.RssInfo{Dir: "."})
+	s.SocketMode = 0o600
+
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+	waitForSocket(t, socketPath)
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected a socket with mode 600, received %s", info.Mode())
+	}
+
+	resp, err := unixClient(socketPath).Get("http://www.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || string(body) != "<title>home</title>" {
+		t.Errorf("expected the home page over the socket, received %d %q", resp.StatusCode, body)
+	}
+
+	s.Close()
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("expected the server to be closed, received %v", err)
+	}
+
+	if _, err := os.Stat(socketPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the socket to be removed when the server closed, received %v", err)
+	}
+}
+
+func TestServerReplacesAStaleUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "andrew.sock")
+
+	// A socket left behind by a server that was killed, rather than closed.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := andrew.NewServer(fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, "unix:"+socketPath, "http://localhost", andrew.RssInfo{Dir: "."})
+	t.Cleanup(func() { s.Close() })
+
+	go s.ListenAndServe()
+	waitForSocket(t, socketPath)
+
+	// One that's still being listened on is left alone.
+	second := andrew.NewServer(fstest.MapFS{}, "unix:"+socketPath, "http://localhost", andrew.RssInfo{Dir: "."})
+	if err := second.ListenAndServe(); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("expected an error saying something is already listening, received %v", err)
+	}
+}
+
+func TestServerWontReplaceAFileWithAUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "andrew.sock")
+	if err := os.WriteFile(socketPath, []byte("precious"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := andrew.NewServer(fstest.MapFS{}, "unix:"+socketPath, "http://localhost", andrew.RssInfo{Dir: "."})
+	if err := s.ListenAndServe(); err == nil || !strings.Contains(err.Error(), "isn't a socket") {
+		t.Errorf("expected an error saying the file isn't a socket, received %v", err)
+	}
+
+	if content, _ := os.ReadFile(socketPath); string(content) != "precious" {
+		t.Errorf("expected the file to be left alone, received %q", content)
+	}
+}
+
+func TestServerNeedsSystemdToListenOnSystemd(t *testing.T) {
+	t.Parallel()
+
+	s := andrew.NewServer(fstest.MapFS{}, "systemd", "http://localhost", andrew.RssInfo{Dir: "."})
+	if err := s.ListenAndServe(); err == nil || !strings.Contains(err.Error(), "systemd socket unit") {
+		t.Errorf("expected an error saying Andrew wasn't started by systemd, received %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package andrew
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// markdown renders Markdown the way GitHub does. Raw html inside the Markdown is passed
+// through, because everything Andrew serves was written by the site's author.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(goldmarkhtml.WithUnsafe()),
+)
+
+// defaultMarkdownLayout wraps a Markdown page when there's no layout file to be found, so
+// the page is still a whole html document with a title.
+const defaultMarkdownLayout = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .AndrewTitle }}</title>
+</head>
+<body>
+{{ .AndrewContent }}
+</body>
+</html>
+`
+
+// markdownBodyPlaceholder stands in for a Markdown page's rendered body in its layout until
+// the page has been rendered. The body isn't template source: a code block showing
+// {{ .Page.Title }} shows it, rather than running it.
+const markdownBodyPlaceholder = "\x00AndrewMarkdownBody\x00"
+
+// markdownPage is what a Markdown page knows about itself from its front matter, which it
+// can't express with <title> and <meta> elements the way an html page does.
+type markdownPage struct {
+	sourcePath  string // The .md file, as opposed to the .html address it's served at.
+	title       string
+	publishTime time.Time
+	tags        []string
+	meta        map[string]string
+	body        []byte // The rendered Markdown, put in place of markdownBodyPlaceholder by withBody.
+}
+
+// withBody puts the page's rendered Markdown into content, once content's partials and
+// template have been rendered. content is returned as it is for a page that isn't Markdown.
+func (m *markdownPage) withBody(content []byte) []byte {
+	if m == nil {
+		return content
+	}
+
+	return bytes.Replace(content, []byte(markdownBodyPlaceholder), m.body, 1)
+}
+
+// readPageContent returns the html for the page at pagePath. Usually that's just the file
+// at pagePath. If there's no such file but there is a Markdown file for it, post.md for
+// post.html or post, then the Markdown is rendered into its layout, and the returned
+// markdownPage carries the front matter. It's nil for any other kind of page.
+func readPageContent(siteFiles fs.FS, pagePath string) ([]byte, *markdownPage, error) {
+	content, err := fs.ReadFile(siteFiles, pagePath)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return content, nil, err
+	}
+
+	markdownPath, ok := markdownSourceFor(siteFiles, pagePath)
+	if !ok {
+		return nil, nil, err
+	}
+
+	return renderMarkdownPage(siteFiles, markdownPath, pagePath)
+}
+
+// markdownSourceFor finds the Markdown file that would be served at pagePath, if there is
+// one: post.md for post.html, and also for post, which is where a request for post/ ends up.
+func markdownSourceFor(siteFiles fs.FS, pagePath string) (string, bool) {
+	var candidate string
+
+	switch path.Ext(pagePath) {
+	case ".html":
+		candidate = strings.TrimSuffix(pagePath, ".html") + ".md"
+	case "":
+		candidate = pagePath + ".md"
+	default:
+		return "", false
+	}
+
+	info, err := fs.Stat(siteFiles, candidate)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+
+	return candidate, true
+}
+
+// markdownUrlPath is the address a Markdown file is served at, and listed under.
+func markdownUrlPath(markdownPath string) string {
+	return strings.TrimSuffix(markdownPath, ".md") + ".html"
+}
+
+// renderMarkdownPage turns a Markdown file into a whole html page. The Markdown is rendered,
+// and a placeholder for it dropped into the {{ .AndrewContent }} slot of a layout, found by searching upwards
+// from the Markdown file the same way partials are: front matter can name one with
+// "layout: post.html", and otherwise Andrew looks for .AndrewLayout.html. "layout: none",
+// or no layout to be found, gets a plain html document.
+// Any partials in the layout are left for the usual page rendering to take care of, and the
+// rendered Markdown is kept in the markdownPage until that's done, for withBody.
+func renderMarkdownPage(siteFiles fs.FS, markdownPath string, pagePath string) ([]byte, *markdownPage, error) {
+	source, err := fs.ReadFile(siteFiles, markdownPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	front, body := splitFrontMatter(source)
+
+	page := &markdownPage{sourcePath: markdownPath, meta: map[string]string{}}
+	for key, value := range front {
+		switch key {
+		case "title":
+			page.title = value
+		case "date", "publish-time", "andrew-publish-time":
+			page.meta["andrew-publish-time"] = value
+			page.publishTime, _ = parsePublishTime(value)
+		case "tags":
+			page.tags = splitList(value)
+			page.meta["andrew-tags"] = strings.Join(page.tags, ", ")
+		case "draft":
+			page.meta["andrew-draft"] = value
+		default:
+			page.meta[key] = value
+		}
+	}
+
+	if page.title == "" {
+		page.title = path.Base(pagePath)
+	}
+
+	var rendered bytes.Buffer
+	if err := markdown.Convert(body, &rendered); err != nil {
+		return nil, nil, err
+	}
+	page.body = rendered.Bytes()
+
+	placeholder := []byte(markdownBodyPlaceholder)
+
+	layoutName, named := front["layout"]
+	if layoutName == noLayout {
+		return fillLayout([]byte(defaultMarkdownLayout), page.title, nil, placeholder), page, nil
+	}
+
+	layout, found, err := readLayout(siteFiles, markdownPath, layoutName, named)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !found {
+		layout = []byte(defaultMarkdownLayout)
+	}
+
+	content := fillLayout(layout, page.title, nil, placeholder)
+
+	return content, page, nil
+}
+
+// splitFrontMatter separates the front matter at the top of a Markdown file from the
+// Markdown itself. Front matter sits between two lines of ---, one "key: value" per line:
+//
+//	---
+//	title: My first post
+//	date: 2025-03-30
+//	tags: [go, web]
+//	---
+//
+// A key can also be followed by a list, one "- item" per line. Lists come back joined with
+// commas, which is how splitList reads them. This is the subset of YAML that front matter
+// uses in practice; anything fancier is ignored.
+func splitFrontMatter(source []byte) (map[string]string, []byte) {
+	front := map[string]string{}
+
+	normalised := bytes.ReplaceAll(source, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(normalised, []byte("---\n")) {
+		return front, source
+	}
+
+	rest := normalised[len("---\n"):]
+	end := bytes.Index(rest, []byte("\n---"))
+	if end == -1 {
+		return front, source
+	}
+
+	body := rest[end+len("\n---"):]
+	body = bytes.TrimPrefix(body, []byte("\n"))
+
+	var listKey string
+	scanner := bufio.NewScanner(bytes.NewReader(rest[:end]))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if listKey != "" && strings.HasPrefix(trimmed, "- ") {
+			item := unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "- ")))
+			if front[listKey] == "" {
+				front[listKey] = item
+			} else {
+				front[listKey] += ", " + item
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(trimmed, ":")
+		if !found {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		listKey = ""
+		if value == "" {
+			listKey = key
+		}
+
+		front[key] = unquote(value)
+	}
+
+	return front, body
+}
+
+// splitList reads a front matter list, written either as [a, b] or as a, b.
+func splitList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(value), "["), "]")
+
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = unquote(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// unquote drops one pair of matching quotes from around a front matter value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
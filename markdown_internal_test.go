@@ -0,0 +1,69 @@
+package andrew
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		source        string
+		expectedFront map[string]string
+		expectedBody  string
+	}{
+		{
+			name:          "no front matter",
+			source:        "# Title\n",
+			expectedFront: map[string]string{},
+			expectedBody:  "# Title\n",
+		},
+		{
+			name:          "inline values",
+			source:        "---\ntitle: 'Quoted: title'\ntags: [go, web]\n# a comment\n---\nbody\n",
+			expectedFront: map[string]string{"title": "Quoted: title", "tags": "[go, web]"},
+			expectedBody:  "body\n",
+		},
+		{
+			name:          "lists",
+			source:        "---\r\ntags:\r\n  - go\r\n  - \"web\"\r\ndraft: true\r\n---\r\nbody\r\n",
+			expectedFront: map[string]string{"tags": "go, web", "draft": "true"},
+			expectedBody:  "body\n",
+		},
+		{
+			name:          "unterminated front matter is Markdown",
+			source:        "---\ntitle: nope\n",
+			expectedFront: map[string]string{},
+			expectedBody:  "---\ntitle: nope\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			front, body := splitFrontMatter([]byte(tc.source))
+
+			if diff := cmp.Diff(tc.expectedFront, front); diff != "" {
+				t.Error(diff)
+			}
+
+			if string(body) != tc.expectedBody {
+				t.Errorf("expected body %q, received %q", tc.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []string{"[go, web]", "go, web", ` ["go", 'web', ] `} {
+		if diff := cmp.Diff([]string{"go", "web"}, splitList(value)); diff != "" {
+			t.Errorf("%q: %s", value, diff)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package andrew_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/playtechnique/andrew"
+)
+
+func TestMarkdownPageIsServedAsHtmlInsideItsLayout(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		".AndrewLayout.html": {Data: []byte(`<html><head><title>{{ .AndrewTitle }}</title></head><body class="layout">{{ .AndrewContent }}</body></html>`)},
+		"post.md": {Data: []byte(`---
+title: My <first> post
+---
+# Hello
+
+Some *Markdown*.
+`)},
+	})
+
+	for _, address := range []string{"/post.html", "/post/"} {
+		resp, err := http.Get(s.BaseUrl + address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: unexpected status %q", address, resp.Status)
+		}
+
+		if contentType := resp.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+			t.Errorf("%s: expected an html Content-Type, received %q", address, contentType)
+		}
+
+		for _, expected := range []string{
+			`<title>My &lt;first&gt; post</title>`,
+			`<body class="layout"><h1>Hello</h1>`,
+			`<p>Some <em>Markdown</em>.</p>`,
+		} {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("%s: expected %q in %q", address, expected, body)
+			}
+		}
+	}
+}
+
+func TestMarkdownPageHasOneAddress(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"blog/my post.md": {Data: []byte("# Hello\n")},
+		"blog/notes":      {Data: []byte("not markdown")},
+	})
+
+	requireRedirect(t, s.BaseUrl+"/blog/my%20post", http.StatusMovedPermanently, "/blog/my%20post.html")
+	requireRedirect(t, s.BaseUrl+"/blog/my%20post/?page=2", http.StatusMovedPermanently, "/blog/my%20post.html?page=2")
+	requireStatus(t, s.BaseUrl+"/blog/my%20post.html", http.StatusOK)
+
+	// A file without an extension is served as it is, even with a Markdown page beside it.
+	requireStatus(t, s.BaseUrl+"/blog/notes", http.StatusOK)
+
+	sitemap, err := andrew.GenerateSiteMap(s.SiteFiles, s.BaseUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(sitemap), s.BaseUrl+"/blog/my%20post.html") {
+		t.Errorf("expected the sitemap to list the page at the address it's redirected to, received %s", sitemap)
+	}
+}
+
+func TestMarkdownPageWithoutALayoutIsAWholeDocument(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"notes/todo.md": {Data: []byte("- [x] write\n- [ ] publish\n")},
+	}}
+
+	page, err := server.NewPage("notes/todo.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"<!DOCTYPE html>", "<title>todo.html</title>", `<input checked="" disabled="" type="checkbox"`} {
+		if !strings.Contains(page.Content, expected) {
+			t.Errorf("expected %q in %q", expected, page.Content)
+		}
+	}
+}
+
+func TestMarkdownPageCanNameItsLayout(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		".AndrewLayout.html":    {Data: []byte(`default {{ .AndrewContent }}`)},
+		"blog/post-layout.html": {Data: []byte(`post {{ .AndrewContent }}`)},
+		"blog/2025/post.md":     {Data: []byte("---\nlayout: post-layout.html\n---\nhello\n")},
+	}}
+
+	page, err := server.NewPage("blog/2025/post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(page.Content, "post <p>hello</p>") {
+		t.Errorf("expected the named layout, received %q", page.Content)
+	}
+}
+
+func TestMarkdownPageNamingAMissingLayoutIsAnError(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"post.md": {Data: []byte("---\nlayout: nowhere.html\n---\nhello\n")},
+	}}
+
+	if _, err := server.NewPage("post.html"); err == nil {
+		t.Error("expected an error for a layout that doesn't exist")
+	}
+}
+
+func TestHtmlPageWinsOverMarkdownWithTheSameName(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"index.html": {Data: []byte("{{ .AndrewTableOfContents }}")},
+		"post.html":  {Data: []byte("<title>from html</title>")},
+		"post.md":    {Data: []byte("---\ntitle: from markdown\n---\n")},
+	}}
+
+	page, err := server.NewPage("post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Title != "from html" {
+		t.Errorf("expected the html page, received %q", page.Title)
+	}
+
+	index, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(index.Content, "post.html") != 1 || strings.Contains(index.Content, "from markdown") {
+		t.Errorf("expected post.html to be listed once, as the html page, in %q", index.Content)
+	}
+}
+
+func TestMarkdownFrontMatterIsUsedInListings(t *testing.T) {
+	t.Parallel()
+
+	siteFiles := fstest.MapFS{
+		"index.html": {Data: []byte("{{ .AndrewTableOfContents }}")},
+		"post.md": {Data: []byte(`---
+title: "Front matter title"
+date: 2025-03-30
+tags:
+  - go
+  - web
+---
+body
+`)},
+		"draft.md": {Data: []byte("---\ntitle: Not yet\ndraft: true\n---\n")},
+	}
+
+	server := andrew.Server{SiteFiles: siteFiles}
+
+	index, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`href="post.html"`, "Front matter title", "2025-03-30"} {
+		if !strings.Contains(index.Content, expected) {
+			t.Errorf("expected %q in the table of contents %q", expected, index.Content)
+		}
+	}
+
+	if strings.Contains(index.Content, "Not yet") {
+		t.Errorf("expected the draft to be left out of %q", index.Content)
+	}
+
+	page, err := server.NewPage("post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(page.Tags, ",") != "go,web" {
+		t.Errorf("expected tags go and web, received %q", page.Tags)
+	}
+
+	feed, err := andrew.GenerateRssFeed(siteFiles, "https://example.com", andrew.RssInfo{Dir: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(feed), "<title>Front matter title</title>") || !strings.Contains(string(feed), "https://example.com/post.html") {
+		t.Errorf("expected the Markdown page in the rss feed %q", feed)
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(siteFiles, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(sitemap), "<loc>https://example.com/post.html</loc>") || strings.Contains(string(sitemap), "post.md") {
+		t.Errorf("expected the Markdown page to be listed as post.html in %q", sitemap)
+	}
+}
+
+func TestMarkdownCodeBlocksShowTemplateSyntaxRatherThanRunningIt(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		".AndrewLayout.html":         {Data: []byte(`<html><head><title>{{ .AndrewTitle }}</title></head><body>{{ .AndrewPartialFileNav.html }}<h1>{{ .Page.Title }}</h1>{{ .AndrewContent }}</body></html>`)},
+		".AndrewPartialFileNav.html": {Data: []byte(`<nav>nav</nav>`)},
+		"templates.md": {Data: []byte("---\ntitle: Templates\n---\n" +
+			"Use the title like this:\n\n" +
+			"```\n{{ .Page.Title }}\n{{ .AndrewPartialFileNav.html }}\n{{ if .Page.Title }}\n```\n")},
+	})
+
+	resp, err := http.Get(s.BaseUrl + "/templates.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected an unbalanced {{ if }} in a code block not to break the page, received %q", resp.Status)
+	}
+
+	// The layout is still a template; the Markdown isn't.
+	for _, expected := range []string{
+		`<nav>nav</nav><h1>Templates</h1>`,
+		"<code>{{ .Page.Title }}\n{{ .AndrewPartialFileNav.html }}\n{{ if .Page.Title }}\n</code>",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in %q", expected, body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package andrew
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// allowedMethods are the methods the site's pages, assets, feeds, sitemap and robots.txt
+// answer, for the Allow header.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
+// allowMethods answers GET and HEAD requests with next, and every other method itself:
+// OPTIONS with the methods that are allowed, and anything else with a 405. A HEAD request
+// gets the same status and headers as a GET, including the Content-Length of the body it
+// isn't sent.
+func allowMethods(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestsByMethodCounter.WithLabelValues(methodLabel(r.Method)).Inc()
+
+		switch r.Method {
+		case http.MethodGet:
+			next(w, r)
+
+		case http.MethodHead:
+			recorder := &headRecorder{ResponseWriter: w}
+			next(recorder, r)
+			recorder.finish()
+
+		case http.MethodOptions:
+			w.Header().Set("Allow", allowedMethods)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", allowedMethods)
+			http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// methodLabel keeps the method label of requestsByMethodCounter to the methods HTTP defines,
+// so a client inventing methods can't add labels.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+
+	return "OTHER"
+}
+
+// headRecorder answers a HEAD request with what a handler would have answered a GET with,
+// minus the body. It holds the status back until the handler is done, so it can set the
+// Content-Length the body would have had.
+type headRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *headRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *headRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	// net/http works out a GET's Content-Type from its body, so do the same with the body
+	// a HEAD isn't sent.
+	if _, set := r.Header()["Content-Type"]; !set && r.size == 0 && len(b) > 0 {
+		r.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+
+	r.size += len(b)
+
+	return len(b), nil
+}
+
+// finish writes the status the handler wrote, with the Content-Length of the body it wrote.
+func (r *headRecorder) finish() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	if r.Header().Get("Content-Length") == "" && r.status != http.StatusNoContent && r.status != http.StatusNotModified {
+		r.Header().Set("Content-Length", strconv.Itoa(r.size))
+	}
+
+	r.ResponseWriter.WriteHeader(r.status)
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter.
+func (r *headRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package andrew_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func methodsSite() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html": {Data: []byte(`<title>home</title><p>` + strings.Repeat("long page ", 1000) + `</p>`)},
+		"style.css":  {Data: []byte(`body {}`)},
+	}
+}
+
+func doRequest(t *testing.T, method string, url string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := noRedirectClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, body
+}
+
+func TestHeadAnswersLikeGetWithoutABody(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, methodsSite())
+
+	for _, requested := range []string{"/", "/style.css", "/sitemap.xml", "/rss.xml", "/robots.txt", "/missing.html"} {
+		get, getBody := doRequest(t, http.MethodGet, s.BaseUrl+requested)
+		head, headBody := doRequest(t, http.MethodHead, s.BaseUrl+requested)
+
+		if head.StatusCode != get.StatusCode {
+			t.Errorf("%s: expected HEAD status %d, received %d", requested, get.StatusCode, head.StatusCode)
+		}
+
+		if len(headBody) != 0 {
+			t.Errorf("%s: expected HEAD to have no body, received %d bytes", requested, len(headBody))
+		}
+
+		if head.ContentLength != int64(len(getBody)) {
+			t.Errorf("%s: expected HEAD Content-Length %d, received %d", requested, len(getBody), head.ContentLength)
+		}
+
+		for _, header := range []string{"Content-Type", "Cache-Control", "X-Content-Type-Options"} {
+			if head.Header.Get(header) != get.Header.Get(header) {
+				t.Errorf("%s: expected HEAD %s %q, received %q", requested, header, get.Header.Get(header), head.Header.Get(header))
+			}
+		}
+	}
+}
+
+func TestOptionsListsAllowedMethods(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, methodsSite())
+
+	for _, requested := range []string{"/", "/sitemap.xml", "/rss.xml"} {
+		resp, body := doRequest(t, http.MethodOptions, s.BaseUrl+requested)
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("%s: expected status %d, received %d", requested, http.StatusNoContent, resp.StatusCode)
+		}
+
+		if resp.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s: expected Allow %q, received %q", requested, "GET, HEAD, OPTIONS", resp.Header.Get("Allow"))
+		}
+
+		if len(body) != 0 {
+			t.Errorf("%s: expected no body, received %q", requested, body)
+		}
+	}
+}
+
+func TestOtherMethodsAreNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, methodsSite())
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, "BREW"} {
+		for _, requested := range []string{"/", "/style.css", "/sitemap.xml", "/rss.xml", "/robots.txt"} {
+			resp, body := doRequest(t, method, s.BaseUrl+requested)
+
+			if resp.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s: expected status %d, received %d", method, requested, http.StatusMethodNotAllowed, resp.StatusCode)
+			}
+
+			if resp.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+				t.Errorf("%s %s: expected Allow %q, received %q", method, requested, "GET, HEAD, OPTIONS", resp.Header.Get("Allow"))
+			}
+
+			if strings.Contains(string(body), "long page") {
+				t.Errorf("%s %s: expected the page not to be served", method, requested)
+			}
+		}
+	}
+
+	_, metrics := doRequest(t, http.MethodGet, s.BaseUrl+"/metrics")
+	for _, want := range []string{
+		`andrew_http_requests_by_method_total{method="POST"}`,
+		`andrew_http_requests_by_method_total{method="OTHER"}`,
+		`andrew_http_request_duration_seconds_count{code="405",handler="page",site=""}`,
+	} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("expected /metrics to contain %s", want)
+		}
+	}
+}
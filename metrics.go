@@ -0,0 +1,233 @@
+package andrew
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// allRequestsCounter tracks the total number of requests made.
+// Note there can be many requests for a single page, as css etc is served.
+var allRequestsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "andrew_server_serve_allrequests",
+	Help: "The total number of all requests received by the andrew server",
+})
+
+// The metrics below with a site label are split by the site that answered, when Andrew serves
+// several sites with --sites. A site is named by its first host; with one site, the label is
+// empty.
+
+// allRequestsErrorsAggregatedCounter tracks all of the error codes generated,
+// aggregated into one number. The aggregation is to reduce the cardinality of the metrics in Prometheus, which can
+// get fairly gnarly as bots and scammers try downloading random URLs from the website.
+var allRequestsErrorsAggregatedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_server_serve_allrequests_errorsbypath",
+	Help: "The total number of all non-http 200 requests received by the andrew server",
+}, []string{"site", "path", "status"})
+
+// allHttp200RequestsByPathCounter tracks all of the http 200 paths served,
+// organised by the path that is successfully served.
+// Note there can be many requests for a single page, as css etc is served.
+// Its path label goes through trackedPaths, so it can't grow without bound.
+var allHttp200RequestsByPathCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_server_serve_allrequests_200bypath",
+	Help: "The total number of all http 200 requests received by the andrew server, segregated by path",
+}, []string{"site", "path", "status"})
+
+// requestDuration times every request, split by which kind of handler answered it: a page,
+// an asset such as css or an image, the rss feed, the sitemap or robots.txt.
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "andrew_http_request_duration_seconds",
+	Help:    "How long the andrew server took to answer requests, by site, handler and status code",
+	Buckets: prometheus.DefBuckets,
+}, []string{"site", "handler", "code"})
+
+// responseSize measures the bodies the handlers write.
+var responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "andrew_http_response_size_bytes",
+	Help:    "The size of the response bodies written by the andrew server, by site and handler",
+	Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+}, []string{"site", "handler"})
+
+// requestsInFlight is how many requests are being answered right now.
+var requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "andrew_http_requests_in_flight",
+	Help: "The number of requests the andrew server is answering right now",
+})
+
+// siteRequestsCounter counts requests by the site that answered them, when Andrew serves
+// several sites with --sites. A site is named by its first host.
+var siteRequestsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_site_requests_total",
+	Help: "The number of requests each site served by the andrew server answered, by status code",
+}, []string{"site", "code"})
+
+// tlsCertExpiry is when each certificate Andrew serves https with expires, by its path, so
+// an alert can fire before a renewal that didn't happen becomes an outage. With --sites there
+// can be one for each site.
+var tlsCertExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "andrew_tls_cert_expiry_seconds",
+	Help: "When each certificate the andrew server serves https with expires, in seconds since the epoch",
+}, []string{"cert"})
+
+// renderPhaseDuration breaks a page's render into the phases that can get slow as a site
+// grows: expanding partials, walking the file system for the table of contents, and
+// executing the page's template.
+var renderPhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "andrew_render_phase_duration_seconds",
+	Help:    "How long each phase of rendering a page took",
+	Buckets: prometheus.DefBuckets,
+}, []string{"phase"})
+
+// renderErrorsCounter counts the pages that couldn't be rendered because of a mistake in a
+// template, split by whether the template couldn't be parsed or couldn't be executed.
+var renderErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_render_errors_total",
+	Help: "The number of pages the andrew server couldn't render because of a mistake in a template",
+}, []string{"site", "kind"})
+
+// requestsByMethodCounter counts requests by their method, so requests for pages with
+// methods other than GET and HEAD, which are answered with a 405, can be seen.
+var requestsByMethodCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_http_requests_by_method_total",
+	Help: "The number of requests the andrew server received, by method",
+}, []string{"method"})
+
+// redirectsCounter counts the requests answered with a redirect, by the redirect's status.
+var redirectsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "andrew_redirects_total",
+	Help: "The number of requests the andrew server answered with a redirect",
+}, []string{"site", "status"})
+
+// maxTrackedPaths is how many distinct paths allHttp200RequestsByPathCounter will label for
+// each site. It's generous for a hand-written site; a site past it has its remaining paths
+// counted together under "other".
+var maxTrackedPaths = 1000
+
+// trackedPaths is the cardinality guard for allHttp200RequestsByPathCounter.
+var trackedPaths = &sitePathLabels{sites: map[string]*pathLabels{}}
+
+// sitePathLabels gives each site its own pathLabels, so one big site can't use up the labels
+// of the others.
+type sitePathLabels struct {
+	mu    sync.Mutex
+	sites map[string]*pathLabels
+}
+
+// label returns the label for urlPath on site.
+func (s *sitePathLabels) label(site string, urlPath string) string {
+	s.mu.Lock()
+	labels, ok := s.sites[site]
+	if !ok {
+		labels = &pathLabels{seen: map[string]bool{}}
+		s.sites[site] = labels
+	}
+	s.mu.Unlock()
+
+	return labels.label(urlPath)
+}
+
+// pathLabels hands out path labels for metrics. Only paths of files that were actually found
+// and served reach it, so a bot inventing URLs can't add labels, and it stops adding new
+// labels at maxTrackedPaths in case the site itself is very large.
+type pathLabels struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// label returns urlPath, or "other" once maxTrackedPaths different paths have been seen.
+func (p *pathLabels) label(urlPath string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.seen[urlPath] {
+		return urlPath
+	}
+
+	if len(p.seen) >= maxTrackedPaths {
+		return "other"
+	}
+
+	p.seen[urlPath] = true
+
+	return urlPath
+}
+
+// statusRecorder remembers the status code and the number of body bytes a handler wrote,
+// so middleware can report on them after the handler is done.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real ResponseWriter.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument records the request metrics for a handler that always has the same label.
+func (a *Server) instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return a.instrumentBy(func(*http.Request) string { return handler }, next)
+}
+
+// instrumentBy records the request metrics for next, asking labelFor which handler label
+// each request belongs under.
+func (a *Server) instrumentBy(labelFor func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		handler := labelFor(r)
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		requestDuration.WithLabelValues(a.siteName, handler, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+		responseSize.WithLabelValues(a.siteName, handler).Observe(float64(recorder.size))
+	}
+}
+
+// pageOrAsset labels a request to Serve. Directories and html files are pages, which go
+// through partials and tables of contents; everything else is an asset, served as it is.
+func pageOrAsset(r *http.Request) string {
+	requested := r.URL.Path
+
+	if strings.HasSuffix(requested, "/") {
+		return "page"
+	}
+
+	switch path.Ext(requested) {
+	case ".html", "":
+		return "page"
+	}
+
+	return "asset"
+}
@@ -0,0 +1,68 @@
+package andrew
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathLabelsStopAddingLabelsAtTheLimit(t *testing.T) {
+	original := maxTrackedPaths
+	maxTrackedPaths = 2
+	t.Cleanup(func() { maxTrackedPaths = original })
+
+	labels := &pathLabels{seen: map[string]bool{}}
+
+	for _, tt := range []struct{ path, want string }{
+		{path: "index.html", want: "index.html"},
+		{path: "page.html", want: "page.html"},
+		{path: "third.html", want: "other"},
+		// Paths seen before the limit keep their own label.
+		{path: "index.html", want: "index.html"},
+	} {
+		if got := labels.label(tt.path); got != tt.want {
+			t.Errorf("label(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSitePathLabelsGiveEachSiteItsOwnLimit(t *testing.T) {
+	original := maxTrackedPaths
+	maxTrackedPaths = 1
+	t.Cleanup(func() { maxTrackedPaths = original })
+
+	labels := &sitePathLabels{sites: map[string]*pathLabels{}}
+
+	for _, tt := range []struct{ site, path, want string }{
+		{site: "cats.test", path: "index.html", want: "index.html"},
+		{site: "cats.test", path: "naps.html", want: "other"},
+		// cats.test using up its labels leaves dogs.test's alone.
+		{site: "dogs.test", path: "walks.html", want: "walks.html"},
+		{site: "dogs.test", path: "index.html", want: "other"},
+	} {
+		if got := labels.label(tt.site, tt.path); got != tt.want {
+			t.Errorf("label(%q, %q) = %q, want %q", tt.site, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPageOrAsset(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		requested string
+		want      string
+	}{
+		{requested: "/", want: "page"},
+		{requested: "/blog/", want: "page"},
+		{requested: "/blog", want: "page"},
+		{requested: "/blog/post.html", want: "page"},
+		{requested: "/styles.css", want: "asset"},
+		{requested: "/images/cat.png", want: "asset"},
+	}
+
+	for _, tt := range tests {
+		if got := pageOrAsset(httptest.NewRequest("GET", tt.requested, nil)); got != tt.want {
+			t.Errorf("pageOrAsset(%q) = %q, want %q", tt.requested, got, tt.want)
+		}
+	}
+}
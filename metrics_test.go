@@ -0,0 +1,57 @@
+package andrew_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestMetricsSplitRequestsByHandler requests one of each kind of thing Andrew serves and
+// then checks /metrics has timed and measured each of them under its own handler label.
+func TestMetricsSplitRequestsByHandler(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte("<title>home</title>")},
+		"styles.css": {Data: []byte("body {}")},
+	})
+
+	for _, requested := range []string{"/", "/styles.css", "/rss.xml", "/sitemap.xml", "/robots.txt"} {
+		resp, err := http.Get(s.BaseUrl + requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(s.BaseUrl + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	metrics, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`andrew_http_request_duration_seconds_count{code="200",handler="page",site=""}`,
+		`andrew_http_request_duration_seconds_count{code="200",handler="asset",site=""}`,
+		`andrew_http_request_duration_seconds_count{code="200",handler="rss",site=""}`,
+		`andrew_http_request_duration_seconds_count{code="200",handler="sitemap",site=""}`,
+		`andrew_http_request_duration_seconds_count{code="200",handler="robots",site=""}`,
+		`andrew_http_response_size_bytes_count{handler="page",site=""}`,
+		`andrew_http_requests_in_flight`,
+		`andrew_render_phase_duration_seconds_count{phase="partials"}`,
+		`andrew_render_phase_duration_seconds_count{phase="toc_walk"}`,
+		`andrew_render_phase_duration_seconds_count{phase="template"}`,
+	} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("expected /metrics to contain %s", want)
+		}
+	}
+}
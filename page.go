@@ -8,12 +8,14 @@ import (
 	"log/slog"
 	"path"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/html"
 )
 
@@ -30,6 +32,15 @@ var (
 	parserOnce     sync.Once
 )
 
+// DefaultMaxPartialDepth is how deeply partials can be nested inside partials unless
+// --max-partial-depth says otherwise.
+const DefaultMaxPartialDepth = 10
+
+var (
+	errPartialCycle = errors.New("partial includes itself")
+	errPartialDepth = errors.New("partials nested deeper than the limit")
+)
+
 // partialParser returns the singleton partialParser instance.
 func partialParser() *partialsParser {
 	parserOnce.Do(func() {
@@ -57,6 +68,10 @@ type Page struct {
 	UrlPath     string
 	Content     string
 	PublishTime time.Time
+	// Every <meta name="..." content="..."> element in the page, keyed by name.
+	Meta map[string]string
+	// Tags come from <meta name="andrew-tags" content="go, web">, or a Markdown page's front matter.
+	Tags []string
 }
 
 type TagInfo struct {
@@ -70,11 +85,16 @@ type TagInfo struct {
 // metadata that are convenient to have quick access to, such as the page title or the
 // publish time.
 func (s Server) NewPage(pageUrl string) (Page, error) {
-	pageContent, err := fs.ReadFile(s.SiteFiles, pageUrl)
+	pageContent, markdownPage, err := readPageContent(s.SiteFiles, pageUrl)
 	if err != nil {
 		return Page{}, err
 	}
 
+	// A Markdown page answers to post/ as well as post.html, but it's an html page either way.
+	if markdownPage != nil {
+		pageUrl = markdownUrlPath(markdownPage.sourcePath)
+	}
+
 	// The fs.FS documentation notes that paths should not start with a leading slash.
 	pagePath := strings.TrimPrefix(pageUrl, "/")
 
@@ -83,32 +103,65 @@ func (s Server) NewPage(pageUrl string) (Page, error) {
 		return Page{}, err
 	}
 
-	pagePublishTime, err := getPublishTime(s.SiteFiles, pagePath, pageContent)
+	pagePublishTime, err := getPublishTime(s.SiteFiles, sourcePath(pagePath, markdownPage), pageContent)
 
 	if err != nil {
 		return Page{}, err
 	}
 
-	renderedPageContent, err := renderPartialFiles(s.SiteFiles, pagePath, pageContent)
+	site := s.siteData()
+
+	partialData, err := partialTemplateData(s.SiteFiles, pagePath, pageContent, markdownPage, site)
 	if err != nil {
 		return Page{}, err
 	}
 
-	page := Page{Content: string(renderedPageContent), PublishTime: pagePublishTime, Title: pageTitle, UrlPath: pageUrl}
-
-	siblings, err := s.GetSiblingsAndChildren(page.UrlPath)
+	funcs := templateFuncs(s.listedFiles(pagePath), site, pagePath, s.partialDepth())
 
+	renderedPageContent, err := renderPartialFiles(s.SiteFiles, pagePath, pageContent, partialData, funcs, s.partialDepth())
 	if err != nil {
-		return page, err
+		return Page{}, err
 	}
 
-	orderedSiblings := SortPagesByDate(siblings)
+	// Only html pages have meta elements. Looking for them in images and the like on every
+	// request would be wasted work.
+	var meta map[string]string
+	if path.Ext(pagePath) == ".html" {
+		meta, err = GetMetaElements(renderedPageContent)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	// A Markdown page is already in its layout. The page's own title and meta are read
+	// before it goes into one, so a layout can't change what a page says about itself.
+	if markdownPage == nil && path.Ext(pagePath) == ".html" {
+		renderedPageContent, err = renderLayout(s.SiteFiles, pagePath, renderedPageContent, pageTitle, partialData, funcs, s.partialDepth())
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	page := Page{Content: string(renderedPageContent), PublishTime: pagePublishTime, Title: pageTitle, UrlPath: pageUrl, Meta: meta}
+	page = withFrontMatter(page, markdownPage)
+
+	// Finding the siblings means rendering every page under this one's directory, which a
+	// page without a table of contents, like a 404 page, shouldn't pay for.
+	var orderedSiblings []Page
+	if tableOfContentsUser.MatchString(page.Content) {
+		siblings, err := s.GetSiblingsAndChildren(page.UrlPath)
+		if err != nil {
+			return page, err
+		}
+
+		orderedSiblings = SortPagesByDate(siblings)
+	}
 
 	// Only execute templates for html files, not pngs or other kinds of file.
 	// This is so the template rendering engine doesn't receive a binary blob, which
 	// makes it panic.
 	if strings.HasSuffix(page.UrlPath, ".html") {
-		contentWithContents, err := RenderTableOfContents(orderedSiblings, page)
+		contentWithContents, err := renderPageTemplate(orderedSiblings, page, templateData(page, site), funcs)
 		if err != nil {
 			return Page{}, err
 		}
@@ -116,6 +169,8 @@ func (s Server) NewPage(pageUrl string) (Page, error) {
 		page.Content = string(contentWithContents)
 	}
 
+	page.Content = string(markdownPage.withBody([]byte(page.Content)))
+
 	return page, nil
 }
 
@@ -135,13 +190,7 @@ func getPublishTime(siteFiles fs.FS, pagePath string, pageContent []byte) (time.
 	metaPublishTime, ok := meta["andrew-publish-time"]
 
 	if ok {
-		andrewCreatedAt, err := time.Parse(time.DateTime, metaPublishTime)
-
-		// Check if the error is of type *time.ParseError as this indicates
-		// we may have no timestamp with the date
-		if _, ok := err.(*time.ParseError); ok {
-			andrewCreatedAt, err = time.Parse(time.DateOnly, metaPublishTime)
-		}
+		andrewCreatedAt, err := parsePublishTime(metaPublishTime)
 
 		// The errors that come out of time.Parse are all not interesting to me; we just want
 		// to use those errors to tell us if it's safe to set PublishTime to the value of the
@@ -154,39 +203,163 @@ func getPublishTime(siteFiles fs.FS, pagePath string, pageContent []byte) (time.
 	return publishTime, nil
 }
 
+// parsePublishTime reads a publish time written as YYYY-MM-DD HH:MM:SS, as YYYY-MM-DD, or,
+// because it's what Markdown front matter often has, as an RFC 3339 timestamp.
+func parsePublishTime(value string) (time.Time, error) {
+	publishTime, err := time.Parse(time.DateTime, value)
+
+	// Check if the error is of type *time.ParseError as this indicates
+	// we may have no timestamp with the date
+	if _, ok := err.(*time.ParseError); ok {
+		publishTime, err = time.Parse(time.DateOnly, value)
+	}
+
+	if _, ok := err.(*time.ParseError); ok {
+		publishTime, err = time.Parse(time.RFC3339, value)
+	}
+
+	return publishTime, err
+}
+
+// sourcePath is the file a page was read from: the page itself, or its Markdown.
+func sourcePath(pagePath string, markdownPage *markdownPage) string {
+	if markdownPage != nil {
+		return markdownPage.sourcePath
+	}
+
+	return pagePath
+}
+
+// withFrontMatter fills in the Page fields that a Markdown page's front matter sets, over
+// the top of what was read from its html. An html page has no front matter, so its tags come
+// from <meta name="andrew-tags">.
+func withFrontMatter(page Page, markdownPage *markdownPage) Page {
+	if markdownPage == nil {
+		if tags, ok := page.Meta["andrew-tags"]; ok {
+			page.Tags = splitList(tags)
+		}
+		return page
+	}
+
+	page.Title = markdownPage.title
+	page.Tags = markdownPage.tags
+
+	if !markdownPage.publishTime.IsZero() {
+		page.PublishTime = markdownPage.publishTime
+	}
+
+	if page.Meta == nil {
+		page.Meta = map[string]string{}
+	}
+	for key, value := range markdownPage.meta {
+		page.Meta[key] = value
+	}
+
+	return page
+}
+
 // pagesInDir walks startDir and returns a Page for every html page at or beneath it, with
 // each UrlPath relative to the root of siteFiles, unsorted.
-func pagesInDir(siteFiles fs.FS, startDir string) ([]Page, error) {
+// We don't list index files in our collection of pages, because I don't
+// want a link back to a page that contains only links.
+func pagesInDir(siteFiles fs.FS, startDir string, maxPartialDepth int) ([]Page, error) {
+	return walkPages(siteFiles, startDir, false, maxPartialDepth)
+}
+
+// walkPages is pagesInDir with a choice about index pages. The sitemap wants them, because
+// a search engine should know about every page; the tables of contents and the rss feed don't.
+func walkPages(siteFiles fs.FS, startDir string, includeIndexPages bool, maxPartialDepth int) ([]Page, error) {
 	pages := []Page{}
 
-	slog.Debug("pagesInDir", "startDir", startDir)
+	err := eachPage(siteFiles, startDir, includeIndexPages, maxPartialDepth, func(page Page) {
+		if !isPublishable(page.UrlPath, page.Meta) {
+			slog.Debug("walkPages", "notPublishable", page.UrlPath)
+			return
+		}
+
+		pages = append(pages, page)
+	})
+
+	return pages, err
+}
+
+// eachPage walks startDir, reads every html page at or beneath it, and hands each one to fn.
+// It's walkPages without the decision about what gets listed, for the few callers that
+// need to see the unlisted pages too, like robots.txt asking crawlers to stay away from drafts.
+func eachPage(siteFiles fs.FS, startDir string, includeIndexPages bool, maxPartialDepth int, fn func(Page)) error {
+	slog.Debug("eachPage", "startDir", startDir)
+
+	ignored := readIgnoreFile(siteFiles)
 
-	err := fs.WalkDir(siteFiles, startDir, func(pagePath string, d fs.DirEntry, err error) error {
+	return fs.WalkDir(siteFiles, startDir, func(pagePath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		slog.Debug("pagesInDir", "currentPath", pagePath)
+		slog.Debug("eachPage", "currentPath", pagePath)
 
-		// We don't list index files in our collection of pages, because I don't
-		// want a link back to a page that contains only links.
-		if d.Name() == "index.html" {
+		// Nothing under a dotted directory is ever listed, so there's no need to read it.
+		if d.IsDir() && pagePath != startDir && isDotPath(pagePath) {
+			return fs.SkipDir
+		}
+
+		// Nor is anything the site's .andrewignore matches.
+		if pagePath != startDir && ignored.ignores(pagePath, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
 			return nil
 		}
 
-		// If the file we're considering isn't an html file, let's move on with our day.
-		// This also skips every directory, whose name has no .html extension.
-		if path.Ext(d.Name()) != ".html" {
+		// If the file we're considering isn't an html or Markdown file, let's move on with our day.
+		// This also skips every directory, whose name has no extension of either kind.
+		var pageContent []byte
+		var markdownPage *markdownPage
+
+		switch path.Ext(d.Name()) {
+		case ".html":
+			if d.Name() == "index.html" && !includeIndexPages {
+				return nil
+			}
+
+			pageContent, err = fs.ReadFile(siteFiles, pagePath)
+			if err != nil {
+				return err
+			}
+
+		case ".md":
+			markdownPath := pagePath
+			pagePath = markdownUrlPath(markdownPath)
+
+			if path.Base(pagePath) == "index.html" && !includeIndexPages {
+				return nil
+			}
+
+			// When there's an html file with the same name, that's the page that gets served,
+			// and it's listed in its own right.
+			if _, err := fs.Stat(siteFiles, pagePath); err == nil {
+				return nil
+			}
+
+			pageContent, markdownPage, err = renderMarkdownPage(siteFiles, markdownPath, pagePath)
+			if err != nil {
+				slog.Error("skipping Markdown page that can't be rendered", "path", markdownPath, "error", err)
+				return nil
+			}
+
+		default:
 			return nil
 		}
 
-		pageContent, err := fs.ReadFile(siteFiles, pagePath)
+		// A listing only needs the pages' titles and meta elements, so its partials don't get
+		// a SiteData; nothing a partial does with one can change either.
+		partialData, err := partialTemplateData(siteFiles, pagePath, pageContent, markdownPage, SiteData{})
 		if err != nil {
 			return err
 		}
 
 		// Render partials before extracting metadata, so meta tags inside partials are found
-		renderedContent, err := renderPartialFiles(siteFiles, pagePath, pageContent)
+		renderedContent, err := renderPartialFiles(siteFiles, pagePath, pageContent, partialData, listingTemplateFuncs(siteFiles, pagePath), maxPartialDepth)
 		if err != nil {
 			// One page with a broken partial reference shouldn't take out every page
 			// around it. The page itself will still 404 when directly requested.
@@ -199,22 +372,78 @@ func pagesInDir(siteFiles fs.FS, startDir string) ([]Page, error) {
 			return err
 		}
 
-		publishTime, err := getPublishTime(siteFiles, pagePath, renderedContent)
+		publishTime, err := getPublishTime(siteFiles, sourcePath(pagePath, markdownPage), renderedContent)
+		if err != nil {
+			return err
+		}
+
+		meta, err := GetMetaElements(renderedContent)
 		if err != nil {
 			return err
 		}
 
-		pages = append(pages, Page{
+		fn(withFrontMatter(Page{
 			Title:       title,
 			UrlPath:     pagePath,
-			Content:     string(renderedContent),
+			Content:     string(markdownPage.withBody(renderedContent)),
 			PublishTime: publishTime,
-		})
+			Meta:        meta,
+		}, markdownPage))
 
 		return nil
 	})
+}
 
-	return pages, err
+// isPublishable is the one definition of which pages Andrew lists: the tables of contents,
+// the rss feed and the sitemap all get their pages from walkPages, which asks this.
+// Partial files, error pages, anything under a dotted path and drafts are left out. They're
+// still served to anyone who knows the address; they just aren't advertised.
+func isPublishable(pagePath string, meta map[string]string) bool {
+	if isPartialFile(pagePath) || isErrorPage(pagePath) || isDotPath(pagePath) {
+		return false
+	}
+
+	return !isDraft(meta)
+}
+
+// errorPageFinder matches the names of the pages a site can have for a status, like 404.html.
+var errorPageFinder = regexp.MustCompile(`^[45][0-9][0-9]\.html$`)
+
+// isErrorPage reports whether a file is one of the pages Serve shows in place of a page that
+// can't be served, like 404.html.
+func isErrorPage(filePath string) bool {
+	return errorPageFinder.MatchString(path.Base(filePath))
+}
+
+// isPartialFile reports whether a file is a partial, going by the name that
+// {{ .AndrewPartialFile.foo }} directives look up. Partials are html fragments for
+// stitching into pages, not pages in their own right.
+func isPartialFile(filePath string) bool {
+	return strings.HasPrefix(path.Base(filePath), "."+partialParser().fileParentKey)
+}
+
+// isDotPath reports whether any part of a path starts with a dot, like .git/config or
+// drafts/.scratch.html.
+func isDotPath(filePath string) bool {
+	for _, segment := range strings.Split(filePath, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDraft reports whether a page is marked with <meta name="andrew-draft">. The content is
+// optional; only content="false" makes it not a draft, so that a page can be published by
+// editing one word.
+func isDraft(meta map[string]string) bool {
+	draft, ok := meta["andrew-draft"]
+	if !ok {
+		return false
+	}
+
+	return !strings.EqualFold(strings.TrimSpace(draft), "false")
 }
 
 // SetUrlPath updates the UrlPath on a pre-existing Page.
@@ -301,16 +530,33 @@ func getTitle(htmlFilePath string, htmlContent []byte) (string, error) {
 //	renderPartialFiles parses the syntax {{ .AndrewPartialFile foo=bar bam=bas }}, finds the partial
 //
 // file on the file system, reads its contents and performs a template.Execute against it using the key/value pairs as a hashmap.
+// A partial can have partials of its own. Those are found by searching upwards from the partial's directory rather than the
+// page's, and can be nested up to maxPartialDepth deep, where a page's own partials are at depth 1. A partial that ends up including itself is an error, which names
+// every partial in the chain.
 // params:
 //  1. siteFiles: We need this to be able to find the partial files.
 //  2. pagePath: This is the path to the currently-being-evaluated page. Finding partials begins in this page's directory and heads
 //     upwards to find the partial file.
 //  3. pageContent: The page's contents; the partial statement will be parsed out of these.
+//  4. data: What the partial can see of the page and the site, as {{ .Page }}, {{ .Site }} and {{ .Meta }}.
+//     The partial's own key/value pairs are added on top.
+//  5. funcs: The template functions the partial can call.
+//  6. maxPartialDepth: How deeply partials can be nested, from the Server's MaxPartialDepth.
 //
 // retval
 // []byte: an array of bytes representing the new version of pageContent, with the partials included.
 // error: as normal.
-func renderPartialFiles(siteFiles fs.FS, pagePath string, pageContent []byte) ([]byte, error) {
+func renderPartialFiles(siteFiles fs.FS, pagePath string, pageContent []byte, data map[string]any, funcs template.FuncMap, maxPartialDepth int) ([]byte, error) {
+	timer := prometheus.NewTimer(renderPhaseDuration.WithLabelValues("partials"))
+	defer timer.ObserveDuration()
+
+	return renderNestedPartials(siteFiles, pagePath, pageContent, data, funcs, []string{pagePath}, maxPartialDepth)
+}
+
+// renderNestedPartials is renderPartialFiles for content at any depth: the page itself, or a
+// partial inside it. chain is the page followed by the partials that led to this content, so
+// the last in the chain is where its partials are searched for from.
+func renderNestedPartials(siteFiles fs.FS, pagePath string, pageContent []byte, data map[string]any, funcs template.FuncMap, chain []string, maxPartialDepth int) ([]byte, error) {
 	// The parser will parse pageContent for the include statements.
 	partialParser := partialParser()
 	matches := partialParser.regex.FindAllStringSubmatch(string(pageContent), -1)
@@ -338,7 +584,7 @@ func renderPartialFiles(siteFiles fs.FS, pagePath string, pageContent []byte) ([
 
 		// We always need to know the path to the required partial file, so that we
 		// can read in the partial file to insert it into the web page in place of the {{ }} directive.
-		partialFile, err := findPartialFile(siteFiles, pagePath, partialToFind)
+		partialFile, err := findPartialFile(siteFiles, chain[len(chain)-1], partialToFind)
 
 		if err != nil {
 			slog.Debug("renderPartialFiles", "partial not found", pagePath, "error", err)
@@ -346,30 +592,59 @@ func renderPartialFiles(siteFiles fs.FS, pagePath string, pageContent []byte) ([
 			return pageContent, err
 		}
 
+		partialChain := append(slices.Clip(chain), partialFile)
+
+		if slices.Contains(chain, partialFile) {
+			return pageContent, fmt.Errorf("%w: %s", errPartialCycle, strings.Join(partialChain, " -> "))
+		}
+
+		if len(partialChain)-1 > maxPartialDepth {
+			return pageContent, fmt.Errorf("%w of %d: %s", errPartialDepth, maxPartialDepth, strings.Join(partialChain, " -> "))
+		}
+
 		// Read the partial (always needed)
 		partial, err := fs.ReadFile(siteFiles, partialFile)
 		if err != nil {
 			return pageContent, err
 		}
 
+		// The partial's own partials aren't part of its template; they're rendered with their own
+		// key/value pairs. They stand aside as placeholders while the partial is executed.
+		source := string(partial)
+		nested := partialParser.regex.FindAllString(source, -1)
+		for i, directive := range nested {
+			partial = bytes.Replace(partial, []byte(directive), []byte(nestedPartialPlaceholder(i)), 1)
+		}
+
 		// The tag format {{ .AndrewPartialFile spaceman=david }} requires parsing out the key/value pairs.
 		// parsePartialDataTags returns an empty map if there's no data, which is fine for template execution.
 		tags := parsePartialDataTags(dataTagsToParse)
 
 		// Always execute template - works with empty tags (just returns raw content)
-		partialTemplate, err := template.New(partialParser.dataParentKey).Parse(string(partial))
+		partialTemplate, err := template.New(partialFile).Funcs(funcs).Parse(string(partial))
 		if err != nil {
-			panic(err)
+			return pageContent, newRenderError(partialFile, source, err)
 		}
 
 		templateBuffer.Reset() // Clear buffer for reuse in loop
-		err = partialTemplate.Execute(&templateBuffer, tags)
+		err = partialTemplate.Execute(&templateBuffer, withData(data, tags))
 		if err != nil {
-			return templateBuffer.Bytes(), err
+			return pageContent, newRenderError(partialFile, source, err)
 		}
 
 		partialContent = templateBuffer.String()
 
+		for i, directive := range nested {
+			nestedContent, err := renderNestedPartials(siteFiles, pagePath, []byte(directive), data, funcs, partialChain, maxPartialDepth)
+			if err != nil {
+				return pageContent, err
+			}
+
+			// A nested partial inside a {{ range }} or the like is in the output once for
+			// each time the action ran, so every copy of its placeholder is replaced.
+			partialContent = strings.ReplaceAll(partialContent, nestedPartialPlaceholder(i), string(nestedContent))
+		}
+
 		slog.Debug("renderPartialFiles", "partialContent", partialContent)
 		pageContent = []byte(strings.Replace(string(pageContent), m[0], string(partialContent), -1))
 	}
@@ -377,6 +652,12 @@ func renderPartialFiles(siteFiles fs.FS, pagePath string, pageContent []byte) ([
 	return pageContent, nil
 }
 
+// nestedPartialPlaceholder stands in for the i'th partial inside a partial while that partial
+// is executed. It's nothing a template would ever produce by itself.
+func nestedPartialPlaceholder(i int) string {
+	return fmt.Sprintf("\x00AndrewNestedPartial%d\x00", i)
+}
+
 func parsePartialDataTags(data string) map[string]string {
 	slog.Debug("parsePartialDataTags", "inputData", data)
 	var tags = make(map[string]string)
@@ -1,7 +1,9 @@
 package andrew
 
 import (
+	"errors"
 	"maps"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -468,7 +470,7 @@ func TestPagesInDir(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pages, err := pagesInDir(testSiteFiles(), tt.startDir)
+			pages, err := pagesInDir(testSiteFiles(), tt.startDir, DefaultMaxPartialDepth)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -486,7 +488,7 @@ func TestPagesInDir(t *testing.T) {
 }
 
 func TestPagesInDirExtractsTitleAndPublishTime(t *testing.T) {
-	pages, err := pagesInDir(testSiteFiles(), "blog")
+	pages, err := pagesInDir(testSiteFiles(), "blog", DefaultMaxPartialDepth)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -533,12 +535,43 @@ func TestPagesInDirExtractsTitleAndPublishTime(t *testing.T) {
 }
 
 func TestPagesInDirErrorsOnMissingStartDir(t *testing.T) {
-	_, err := pagesInDir(testSiteFiles(), "does-not-exist")
+	_, err := pagesInDir(testSiteFiles(), "does-not-exist", DefaultMaxPartialDepth)
 	if err == nil {
 		t.Fatal("expected an error for a startDir that is not in the fs.FS, got nil")
 	}
 }
 
+// TestWalkPagesOnlyListsPublishablePages covers the exclusions the tables of contents, the
+// rss feed and the sitemap all share.
+func TestWalkPagesOnlyListsPublishablePages(t *testing.T) {
+	siteFiles := fstest.MapFS{
+		"index.html":                   &fstest.MapFile{},
+		"page.html":                    &fstest.MapFile{},
+		"published.html":               &fstest.MapFile{Data: []byte(`<meta name="andrew-draft" content="false">`)},
+		"draft.html":                   &fstest.MapFile{Data: []byte(`<meta name="andrew-draft" content="true">`)},
+		"bare-draft.html":              &fstest.MapFile{Data: []byte(`<meta name="andrew-draft">`)},
+		".AndrewPartialFile.html":      &fstest.MapFile{Data: []byte("<header></header>")},
+		"blog/.AndrewPartialFile.html": &fstest.MapFile{Data: []byte("<footer></footer>")},
+		".git/description.html":        &fstest.MapFile{},
+		"blog/.drafts/wip.html":        &fstest.MapFile{},
+		"blog/.scratch.html":           &fstest.MapFile{},
+	}
+
+	pages, err := walkPages(siteFiles, ".", true, DefaultMaxPartialDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, page := range pages {
+		got = append(got, page.UrlPath)
+	}
+
+	if diff := cmp.Diff([]string{"index.html", "page.html", "published.html"}, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
 // TestPagesInDirSkipsPagesWithBrokenPartials pins the choice to degrade one page rather than
 // fail everything around it: an author who typos a partial name should lose that page from
 // the listings, not the whole rss feed or table of contents that the page appears in.
@@ -550,7 +583,7 @@ func TestPagesInDirSkipsPagesWithBrokenPartials(t *testing.T) {
 		"broken.html": &fstest.MapFile{Data: []byte("<title>Broken</title>{{ .AndrewPartialFile.missing }}")},
 	}
 
-	pages, err := pagesInDir(siteFiles, ".")
+	pages, err := pagesInDir(siteFiles, ".", DefaultMaxPartialDepth)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -602,3 +635,118 @@ func testSiteFiles() fstest.MapFS {
 		},
 	}
 }
+
+func TestNestedPartialsAreRenderedFromTheirOwnDirectory(t *testing.T) {
+	t.Parallel()
+
+	server := Server{SiteFiles: fstest.MapFS{
+		"blog/post.html":                  {Data: []byte(`{{ .AndrewPartialFileHeader.html heading=Hello }}`)},
+		"blog/.AndrewPartialFileNav.html": {Data: []byte(`the blog's nav`)},
+		".AndrewPartialFileHeader.html":   {Data: []byte(`<h1>{{ .heading }}</h1>{{ .AndrewPartialFileNav.html section=blog }}`)},
+		".AndrewPartialFileNav.html":      {Data: []byte(`<nav>{{ .section }}</nav>{{ .AndrewPartialFileFooter.html }}`)},
+		".AndrewPartialFileFooter.html":   {Data: []byte(`<footer>{{ .Page.UrlPath }}</footer>`)},
+	}}
+
+	page, err := server.NewPage("blog/post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<h1>Hello</h1><nav>blog</nav><footer>blog/post.html</footer>`
+	if page.Content != expected {
+		t.Error(cmp.Diff(expected, page.Content))
+	}
+}
+
+func TestNestedPartialsInsideARangeAreRenderedEachTime(t *testing.T) {
+	t.Parallel()
+
+	server := Server{SiteFiles: fstest.MapFS{
+		"index.html":                  {Data: []byte(`<ul>{{ .AndrewPartialFileList.html }}</ul>`)},
+		".AndrewPartialFileList.html": {Data: []byte(`{{ range split "a,b,c" "," }}<li>{{ . }} {{ .AndrewPartialFileItem.html }}</li>{{ end }}`)},
+		".AndrewPartialFileItem.html": {Data: []byte(`item`)},
+	}}
+
+	page, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<ul><li>a item</li><li>b item</li><li>c item</li></ul>`
+	if page.Content != expected {
+		t.Error(cmp.Diff(expected, page.Content))
+	}
+}
+
+func TestPartialThatIncludesItselfIsAnError(t *testing.T) {
+	t.Parallel()
+
+	server := Server{SiteFiles: fstest.MapFS{
+		"index.html":               {Data: []byte(`{{ .AndrewPartialFileA.html }}`)},
+		".AndrewPartialFileA.html": {Data: []byte(`a {{ .AndrewPartialFileB.html }}`)},
+		".AndrewPartialFileB.html": {Data: []byte(`b {{ .AndrewPartialFileA.html }}`)},
+	}}
+
+	_, err := server.NewPage("index.html")
+	if !errors.Is(err, errPartialCycle) {
+		t.Fatalf("expected a cycle error, received %v", err)
+	}
+
+	chain := "index.html -> .AndrewPartialFileA.html -> .AndrewPartialFileB.html -> .AndrewPartialFileA.html"
+	if !strings.Contains(err.Error(), chain) {
+		t.Errorf("expected the error to contain the chain %q, received %q", chain, err)
+	}
+}
+
+func TestPartialsCanOnlyBeNestedSoDeep(t *testing.T) {
+	t.Parallel()
+
+	server := Server{MaxPartialDepth: 2, SiteFiles: fstest.MapFS{
+		"two.html":                     {Data: []byte(`{{ .AndrewPartialFileOne.html }}`)},
+		"three.html":                   {Data: []byte(`{{ .AndrewPartialFileTwo.html }}`)},
+		".AndrewPartialFileTwo.html":   {Data: []byte(`{{ .AndrewPartialFileOne.html }}`)},
+		".AndrewPartialFileOne.html":   {Data: []byte(`{{ .AndrewPartialFileInner.html }}`)},
+		".AndrewPartialFileInner.html": {Data: []byte(`inner`)},
+	}}
+
+	page, err := server.NewPage("two.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Content != "inner" {
+		t.Errorf("expected partials two deep to render, received %q", page.Content)
+	}
+
+	if _, err := server.NewPage("three.html"); !errors.Is(err, errPartialDepth) {
+		t.Errorf("expected a depth error for partials three deep, received %v", err)
+	}
+}
+
+func TestNewPageOnlyReadsMetaElementsFromHtml(t *testing.T) {
+	t.Parallel()
+
+	meta := []byte(`<title>t</title><meta name="andrew-enclosure" content="one.mp3">`)
+	server := Server{SiteFiles: fstest.MapFS{
+		"index.html": {Data: meta},
+		"notes.txt":  {Data: meta},
+	}}
+
+	page, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Meta["andrew-enclosure"] != "one.mp3" {
+		t.Errorf("expected an html page's meta elements, received %v", page.Meta)
+	}
+
+	asset, err := server.NewPage("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(asset.Meta) != 0 {
+		t.Errorf("expected no meta elements from a file that isn't html, received %v", asset.Meta)
+	}
+}
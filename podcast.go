@@ -0,0 +1,245 @@
+package andrew
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// podcastEpisode is what an rss item needs on top of the usual title and link to describe
+// a page as a podcast episode: the audio file itself as an <enclosure>, and the handful of
+// itunes and podcasting 2.0 elements that podcast apps display.
+//
+// Each of these comes from a meta element in the page:
+//
+//	<meta name="andrew-enclosure" content="episode-1.mp3">
+//	<meta name="andrew-podcast-duration" content="00:42:17">
+//	<meta name="andrew-podcast-explicit" content="false">
+//	<meta name="andrew-podcast-image" content="/art/episode-1.jpg">
+//	<meta name="andrew-podcast-episode" content="1">
+//
+// Without andrew-enclosure, the first <audio> element in the page is used instead.
+type podcastEpisode struct {
+	EnclosureUrl    string
+	EnclosureType   string
+	EnclosureLength int64
+	Duration        string
+	Explicit        string
+	Image           string
+	Episode         string
+}
+
+// audioMimeTypes pins the types podcast apps expect for common audio and video files.
+// mime.TypeByExtension depends on whatever mime database the host has installed, which
+// differs between a developer's laptop and a scratch container, so we only fall back on it.
+var audioMimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+}
+
+// newPodcastEpisode returns nil when the page has no audio, which is the case for every
+// page on a site that isn't a podcast.
+// A missing audio file is logged and the page stays in the feed without an enclosure, for
+// the same reason a broken partial only costs its own page: one typo shouldn't take out
+// the whole feed.
+func newPodcastEpisode(f fs.FS, baseUrl string, page Page) *podcastEpisode {
+	src, ok := page.Meta["andrew-enclosure"]
+	if !ok || src == "" {
+		src = firstAudioSource([]byte(page.Content))
+	}
+
+	if src == "" {
+		return nil
+	}
+
+	episode := &podcastEpisode{
+		Duration: page.Meta["andrew-podcast-duration"],
+		Explicit: podcastExplicit(page.Meta["andrew-podcast-explicit"]),
+		Episode:  page.Meta["andrew-podcast-episode"],
+	}
+
+	if image := page.Meta["andrew-podcast-image"]; image != "" {
+		episode.Image, _ = resolvePageLink(baseUrl, page.UrlPath, image)
+	}
+
+	var localPath string
+	episode.EnclosureUrl, localPath = resolvePageLink(baseUrl, page.UrlPath, src)
+
+	episode.EnclosureType = page.Meta["andrew-enclosure-type"]
+	if episode.EnclosureType == "" {
+		episode.EnclosureType = enclosureMimeType(src)
+	}
+
+	// An enclosure hosted somewhere else can't be measured from here. The rss spec wants a
+	// length, and podcast apps treat 0 as "unknown".
+	if localPath == "" {
+		return episode
+	}
+
+	info, err := fs.Stat(f, localPath)
+	if err != nil {
+		slog.Error("leaving out the enclosure of a page whose audio file can't be read", "path", page.UrlPath, "enclosure", localPath, "error", err)
+		return nil
+	}
+
+	episode.EnclosureLength = info.Size()
+
+	return episode
+}
+
+// write emits the episode's elements, indented to sit inside an rss <item>.
+func (e *podcastEpisode) write(w io.Writer) {
+	fmt.Fprintf(w, "\t\t<enclosure url=\"%s\" length=\"%d\" type=\"%s\"/>\n",
+		html.EscapeString(e.EnclosureUrl), e.EnclosureLength, html.EscapeString(e.EnclosureType))
+
+	if e.Duration != "" {
+		fmt.Fprintf(w, "\t\t<itunes:duration>%s</itunes:duration>\n", html.EscapeString(e.Duration))
+	}
+
+	fmt.Fprintf(w, "\t\t<itunes:explicit>%s</itunes:explicit>\n", e.Explicit)
+
+	if e.Image != "" {
+		fmt.Fprintf(w, "\t\t<itunes:image href=\"%s\"/>\n", html.EscapeString(e.Image))
+	}
+
+	if e.Episode != "" {
+		fmt.Fprintf(w, "\t\t<itunes:episode>%s</itunes:episode>\n", html.EscapeString(e.Episode))
+		fmt.Fprintf(w, "\t\t<podcast:episode>%s</podcast:episode>\n", html.EscapeString(e.Episode))
+	}
+}
+
+// resolvePageLink turns a link as written in a page into the absolute URL a feed reader
+// needs, and, when the link points inside the site, the path of the file in the site's
+// fs.FS. Links can be written relative to the page, relative to the site root, or as full
+// URLs, including ones starting // that take the site's scheme; full URLs on some other
+// host have no local path. A local link can be written escaped or not: the file is found
+// by its unescaped path, and the URL is escaped.
+func resolvePageLink(baseUrl string, pagePath string, link string) (string, string) {
+	if strings.HasPrefix(link, "//") {
+		if scheme, _, ok := strings.Cut(baseUrl, "://"); ok {
+			link = scheme + ":" + link
+		}
+	}
+
+	if strings.Contains(link, "://") {
+		if !strings.HasPrefix(link, baseUrl+"/") {
+			return link, ""
+		}
+		link = strings.TrimPrefix(link, baseUrl)
+	}
+
+	if unescaped, err := url.PathUnescape(link); err == nil {
+		link = unescaped
+	}
+
+	var localPath string
+	if strings.HasPrefix(link, "/") {
+		localPath = path.Clean(strings.TrimPrefix(link, "/"))
+	} else {
+		localPath = path.Join(path.Dir(pagePath), link)
+	}
+
+	return baseUrl + "/" + escapeUrlPath(localPath), localPath
+}
+
+// enclosureMimeType works out the type attribute of an <enclosure> from its file extension.
+func enclosureMimeType(src string) string {
+	ext := strings.ToLower(path.Ext(src))
+
+	if mimeType, ok := audioMimeTypes[ext]; ok {
+		return mimeType
+	}
+
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+
+	return "application/octet-stream"
+}
+
+// podcastExplicit normalises andrew-podcast-explicit into the "true" or "false" that Apple
+// Podcasts accepts. Anything that isn't clearly a yes is a no.
+func podcastExplicit(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "explicit":
+		return "true"
+	}
+
+	return "false"
+}
+
+// firstAudioSource returns the src of the first <audio> element in the page, whether it's
+// on the element itself or on the first <source> inside it. It returns an empty string if
+// there's no audio.
+func firstAudioSource(htmlContent []byte) string {
+	doc, err := nethtml.Parse(bytes.NewReader(htmlContent))
+	if err != nil {
+		return ""
+	}
+
+	var audio *nethtml.Node
+
+	var findAudio func(n *nethtml.Node)
+	findAudio = func(n *nethtml.Node) {
+		if audio != nil {
+			return
+		}
+
+		if n.Type == nethtml.ElementNode && n.Data == "audio" {
+			audio = n
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findAudio(c)
+		}
+	}
+
+	findAudio(doc)
+
+	if audio == nil {
+		return ""
+	}
+
+	if src := attribute(audio, "src"); src != "" {
+		return src
+	}
+
+	for c := audio.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == nethtml.ElementNode && c.Data == "source" {
+			if src := attribute(c, "src"); src != "" {
+				return src
+			}
+		}
+	}
+
+	return ""
+}
+
+// attribute returns the value of the named attribute on an html node, or an empty string.
+func attribute(n *nethtml.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,150 @@
+package andrew_test
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/playtechnique/andrew"
+)
+
+func TestGenerateRssFeedDescribesAPodcastEpisode(t *testing.T) {
+	t.Parallel()
+
+	expected := []byte(`<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel>
+	<title>Cast</title>
+	<link>http://localhost:8080</link>
+	<description>Talking.</description>
+	<generator>Andrew</generator>
+	<item>
+		<title>Episode One</title>
+		<link>http://localhost:8080/episodes/one.html</link>
+		<pubDate>Mon, 01 Jan 0001 00:00:00 +0000</pubDate>
+		<source url="http://localhost:8080/rss.xml">Cast</source>
+		<enclosure url="http://localhost:8080/episodes/one.mp3" length="10" type="audio/mpeg"/>
+		<itunes:duration>00:42:17</itunes:duration>
+		<itunes:explicit>true</itunes:explicit>
+		<itunes:image href="http://localhost:8080/art/one.jpg"/>
+		<itunes:episode>1</itunes:episode>
+		<podcast:episode>1</podcast:episode>
+	</item>
+</channel>
+</rss>
+`)
+
+	testFs := fstest.MapFS{
+		"episodes/one.html": {Data: []byte(`<head><title>Episode One</title>
+<meta name="andrew-enclosure" content="one.mp3">
+<meta name="andrew-podcast-duration" content="00:42:17">
+<meta name="andrew-podcast-explicit" content="yes">
+<meta name="andrew-podcast-image" content="/art/one.jpg">
+<meta name="andrew-podcast-episode" content="1">
+</head>`)},
+		"episodes/one.mp3": {Data: []byte("not an mp3")},
+	}
+
+	rssInfo := andrew.RssInfo{Title: "Cast", Dir: ".", Description: "Talking."}
+
+	feed, err := andrew.GenerateRssFeed(testFs, "http://localhost:8080", rssInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(feed, expected) {
+		t.Error(cmp.Diff(string(expected), string(feed)))
+	}
+}
+
+func TestGenerateRssFeedFindsTheEnclosureInAnAudioElement(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		page string
+	}{
+		{name: "src on the audio element", page: `<audio src="/audio/two.ogg"></audio>`},
+		{name: "src on a nested source element", page: `<audio controls><source src="/audio/two.ogg"></audio>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFs := fstest.MapFS{
+				"two.html":       {Data: []byte(tt.page)},
+				"audio/two.ogg":  {Data: []byte("1234")},
+				"audio/skip.ogg": {Data: []byte("not the first audio element")},
+			}
+
+			feed, err := andrew.GenerateRssFeed(testFs, "http://localhost:8080", andrew.RssInfo{Dir: "."})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []byte(`<enclosure url="http://localhost:8080/audio/two.ogg" length="4" type="audio/ogg"/>`)
+			if !bytes.Contains(feed, want) {
+				t.Errorf("expected feed to contain %s, got:\n%s", want, feed)
+			}
+		})
+	}
+}
+
+// TestGenerateRssFeedKeepsAnEpisodeWhoseAudioIsMissing checks that a typo'd audio path only
+// costs the page its enclosure; the page is still in the feed, and a feed with no episodes
+// left in it isn't declared as a podcast.
+func TestGenerateRssFeedKeepsAnEpisodeWhoseAudioIsMissing(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"three.html": {Data: []byte(`<title>Three</title><meta name="andrew-enclosure" content="missing.mp3">`)},
+	}
+
+	feed, err := andrew.GenerateRssFeed(testFs, "http://localhost:8080", andrew.RssInfo{Dir: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(feed, []byte("<title>Three</title>")) {
+		t.Errorf("expected the page to stay in the feed, got:\n%s", feed)
+	}
+
+	for _, unwanted := range []string{"<enclosure", "xmlns:itunes"} {
+		if bytes.Contains(feed, []byte(unwanted)) {
+			t.Errorf("expected no %s in the feed, got:\n%s", unwanted, feed)
+		}
+	}
+}
+
+func TestGenerateRssFeedResolvesEnclosureLinks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{name: "a protocol-relative link elsewhere", src: "//cdn.example.com/one.mp3", want: `<enclosure url="http://cdn.example.com/one.mp3" length="0" type="audio/mpeg"/>`},
+		{name: "a protocol-relative link to the site", src: "//localhost:8080/audio/my episode.mp3", want: `<enclosure url="http://localhost:8080/audio/my%20episode.mp3" length="5" type="audio/mpeg"/>`},
+		{name: "a local path that needs escaping", src: "/audio/my episode.mp3", want: `<enclosure url="http://localhost:8080/audio/my%20episode.mp3" length="5" type="audio/mpeg"/>`},
+		{name: "a local path that's already escaped", src: "/audio/my%20episode.mp3", want: `<enclosure url="http://localhost:8080/audio/my%20episode.mp3" length="5" type="audio/mpeg"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testFs := fstest.MapFS{
+				"one.html":             {Data: []byte(`<meta name="andrew-enclosure" content="` + tt.src + `">`)},
+				"audio/my episode.mp3": {Data: []byte("12345")},
+			}
+
+			feed, err := andrew.GenerateRssFeed(testFs, "http://localhost:8080", andrew.RssInfo{Dir: "."})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(feed, []byte(tt.want)) {
+				t.Errorf("expected feed to contain %s, got:\n%s", tt.want, feed)
+			}
+		})
+	}
+}
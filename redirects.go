@@ -0,0 +1,323 @@
+package andrew
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redirectsFile is the file in the root of the site that lists its redirects, one per line:
+//
+//	/old/path.html /new/path.html 301
+//	/blog/* /posts/:splat
+//
+// The status is 301 unless it says otherwise, and can be 301, 302, 307 or 308. A * at the
+// end of the old path matches anything under it, and :splat in the new path is whatever
+// it matched. Blank lines and lines starting with # are skipped.
+const redirectsFile = "_redirects"
+
+// redirectFromMeta is the meta element a page uses to say which old paths should redirect
+// to it, like <meta name="andrew-redirect-from" content="/old/path.html">. More than one path
+// can be given, separated by commas.
+const redirectFromMeta = "andrew-redirect-from"
+
+// aliasRefreshInterval is how often the site's files are checked for changes that could
+// change the pages' andrew-redirect-from aliases.
+var aliasRefreshInterval = 10 * time.Second
+
+// redirectRule is one line of the redirects file, or one page's alias.
+type redirectRule struct {
+	from   string // A cleaned path, or a path ending in /* to match everything under it.
+	to     string
+	status int
+	page   string // For an alias, the path of the page it's an alias of.
+}
+
+// match reports whether urlPath is redirected by the rule, and where to.
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	prefix, splat := strings.CutSuffix(rule.from, "*")
+	if !splat {
+		if urlPath != rule.from {
+			return "", false
+		}
+		return rule.to, true
+	}
+
+	// /blog/* matches /blog itself, too.
+	if urlPath+"/" == prefix {
+		return strings.ReplaceAll(rule.to, ":splat", ""), true
+	}
+
+	if !strings.HasPrefix(urlPath, prefix) {
+		return "", false
+	}
+
+	return strings.ReplaceAll(rule.to, ":splat", strings.TrimPrefix(urlPath, prefix)), true
+}
+
+// redirectTable is a site's redirects: the rules from its redirects file, then its pages'
+// aliases. Both are kept up to date while Andrew runs. The redirects file is read again
+// whenever it changes. Finding the aliases means reading every page, so it's done in the
+// background: first when Andrew starts serving the site, then whenever the site's files look
+// different, which is checked at most once every aliasRefreshInterval. Requests use the
+// aliases already found in the meantime, only waiting for them the first time.
+// Its zero value is ready to use.
+type redirectTable struct {
+	mu sync.RWMutex
+
+	rulesVersion fileVersion
+	rules        []redirectRule
+
+	aliasesStamp   siteStamp // The site's files as they were when the aliases were found.
+	aliasesChecked time.Time // When the site's files were last compared with aliasesStamp.
+	aliases        []redirectRule
+
+	startAliases   sync.Once
+	aliasesFound   chan struct{} // Closed once the aliases have been found for the first time.
+	findingAliases atomic.Bool   // Whether the aliases are being found in the background.
+}
+
+// start finds the aliases in the background, unless that's been started already.
+func (t *redirectTable) start(siteFiles fs.FS, maxPartialDepth int) {
+	t.startAliases.Do(func() {
+		t.aliasesFound = make(chan struct{})
+		t.findingAliases.Store(true)
+
+		go func() {
+			defer close(t.aliasesFound)
+			defer t.findingAliases.Store(false)
+			t.loadAliases(siteFiles, maxPartialDepth, stampSite(siteFiles))
+		}()
+	})
+}
+
+// lookup finds where urlPath is redirected to, and with which status. Rules from the
+// redirects file are checked first, in the order the file lists them, then the aliases of
+// the pages canSee lets through; the rest are left alone, so an alias can't give away a page
+// the request can't see.
+func (t *redirectTable) lookup(siteFiles fs.FS, urlPath string, maxPartialDepth int, canSee func(pagePath string) bool) (string, int, bool) {
+	t.start(siteFiles, maxPartialDepth)
+	<-t.aliasesFound
+
+	t.mu.RLock()
+	rulesVersion, aliasesChecked := t.rulesVersion, t.aliasesChecked
+	t.mu.RUnlock()
+
+	// The redirects file is one small file, so a change to it is read straight away.
+	if !currentFileVersion(siteFiles, redirectsFile).same(rulesVersion) {
+		t.loadRules(siteFiles)
+	}
+
+	if time.Since(aliasesChecked) > aliasRefreshInterval && t.findingAliases.CompareAndSwap(false, true) {
+		go func() {
+			defer t.findingAliases.Store(false)
+			t.refreshAliases(siteFiles, maxPartialDepth)
+		}()
+	}
+
+	urlPath = path.Clean("/" + urlPath)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, rules := range [][]redirectRule{t.rules, t.aliases} {
+		for _, rule := range rules {
+			if rule.page != "" && !canSee(rule.page) {
+				continue
+			}
+			if to, ok := rule.match(urlPath); ok {
+				return to, rule.status, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+func (t *redirectTable) loadRules(siteFiles fs.FS) {
+	version := currentFileVersion(siteFiles, redirectsFile)
+	rules := []redirectRule{}
+
+	if version.found {
+		content, err := fs.ReadFile(siteFiles, redirectsFile)
+		if err != nil {
+			slog.Error("redirects could not be read", "path", redirectsFile, "error", err)
+		} else {
+			rules = parseRedirects(content)
+			slog.Info("redirects loaded", "path", redirectsFile, "rules", len(rules))
+		}
+	}
+
+	t.mu.Lock()
+	t.rules, t.rulesVersion = rules, version
+	t.mu.Unlock()
+}
+
+// refreshAliases finds the aliases again if the site's files look different from when they
+// were last found.
+func (t *redirectTable) refreshAliases(siteFiles fs.FS, maxPartialDepth int) {
+	stamp := stampSite(siteFiles)
+
+	t.mu.Lock()
+	unchanged := stamp == t.aliasesStamp
+	if unchanged {
+		t.aliasesChecked = time.Now()
+	}
+	t.mu.Unlock()
+
+	if !unchanged {
+		t.loadAliases(siteFiles, maxPartialDepth, stamp)
+	}
+}
+
+// loadAliases reads every page to find its aliases, then swaps them in for the old ones.
+// An alias redirects to the page's own address, the one it's listed under, so an index
+// page's aliases go to its directory.
+func (t *redirectTable) loadAliases(siteFiles fs.FS, maxPartialDepth int, stamp siteStamp) {
+	aliases := []redirectRule{}
+
+	err := eachPage(siteFiles, ".", true, maxPartialDepth, func(page Page) {
+		for _, from := range splitList(page.Meta[redirectFromMeta]) {
+			aliases = append(aliases, redirectRule{
+				from:   path.Clean("/" + from),
+				to:     escapeUrlPath(canonicalUrlPath(page.UrlPath)),
+				status: http.StatusMovedPermanently,
+				page:   page.UrlPath,
+			})
+		}
+	})
+	if err != nil {
+		slog.Error("redirect aliases could not be read", "error", err)
+	}
+
+	t.mu.Lock()
+	t.aliases, t.aliasesStamp, t.aliasesChecked = aliases, stamp, time.Now()
+	t.mu.Unlock()
+}
+
+// siteStamp sums up a site's files by their number, sizes and modification times, so a
+// change to them can be noticed without reading them.
+type siteStamp struct {
+	files    int
+	size     int64
+	modified time.Time
+}
+
+func stampSite(siteFiles fs.FS) siteStamp {
+	var stamp siteStamp
+
+	fs.WalkDir(siteFiles, ".", func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		stamp.files++
+		stamp.size += info.Size()
+		if info.ModTime().After(stamp.modified) {
+			stamp.modified = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return stamp
+}
+
+// parseRedirects reads the rules from a redirects file. A line that can't be understood is
+// logged and skipped, rather than taking the rest of the site's redirects down with it.
+func parseRedirects(content []byte) []redirectRule {
+	rules := []redirectRule{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseRedirect(line)
+		if err != nil {
+			slog.Warn("skipping redirect", "path", redirectsFile, "line", n, "error", err)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func parseRedirect(line string) (redirectRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return redirectRule{}, fmt.Errorf("expected an old path, a new path and optionally a status, got %q", line)
+	}
+
+	rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+
+	if !strings.HasPrefix(rule.from, "/") {
+		return redirectRule{}, fmt.Errorf("%q must start with a /", rule.from)
+	}
+
+	if !strings.HasSuffix(rule.from, "/*") {
+		rule.from = path.Clean(rule.from)
+	}
+
+	if len(fields) == 3 {
+		status, err := strconv.Atoi(fields[2])
+		if err != nil || !isRedirectStatus(status) {
+			return redirectRule{}, fmt.Errorf("%q isn't one of 301, 302, 307 or 308", fields[2])
+		}
+		rule.status = status
+	}
+
+	return rule, nil
+}
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// serveRedirect answers a request that the site's redirects send elsewhere, and reports
+// whether there was one. The request's query string is passed on, unless the redirect has
+// one of its own.
+func (a *Server) serveRedirect(w http.ResponseWriter, r *http.Request) bool {
+	// A page in an area that needs a client certificate only answers to its aliases for
+	// someone who has one.
+	canSee := func(pagePath string) bool {
+		return !a.needsClientCert(pagePath) || clientIdentity(r) != ""
+	}
+
+	to, status, ok := a.shared().redirects.lookup(a.SiteFiles, r.URL.Path, a.partialDepth(), canSee)
+	if !ok {
+		return false
+	}
+
+	if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+		to += "?" + r.URL.RawQuery
+	}
+
+	redirectsCounter.WithLabelValues(a.siteName, strconv.Itoa(status)).Inc()
+	http.Redirect(w, r, to, status)
+
+	return true
+}
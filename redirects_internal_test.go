@@ -0,0 +1,182 @@
+package andrew
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRedirects(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`# a comment
+
+/old.html /new.html
+/temporary/ /elsewhere.html 302
+/blog/* /posts/:splat 308
+/broken.html
+/teapot.html /kettle.html 418
+relative.html /new.html
+`)
+
+	expected := []redirectRule{
+		{from: "/old.html", to: "/new.html", status: http.StatusMovedPermanently},
+		{from: "/temporary", to: "/elsewhere.html", status: http.StatusFound},
+		{from: "/blog/*", to: "/posts/:splat", status: http.StatusPermanentRedirect},
+	}
+
+	received := parseRedirects(content)
+
+	if diff := cmp.Diff(expected, received, cmp.AllowUnexported(redirectRule{})); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	t.Parallel()
+
+	exact := redirectRule{from: "/old.html", to: "/new.html"}
+	splat := redirectRule{from: "/blog/*", to: "/posts/:splat"}
+
+	testCases := []struct {
+		rule     redirectRule
+		urlPath  string
+		expected string
+		matches  bool
+	}{
+		{rule: exact, urlPath: "/old.html", expected: "/new.html", matches: true},
+		{rule: exact, urlPath: "/old.html/more", matches: false},
+		{rule: splat, urlPath: "/blog/2024/hello.html", expected: "/posts/2024/hello.html", matches: true},
+		{rule: splat, urlPath: "/blog", expected: "/posts/", matches: true},
+		{rule: splat, urlPath: "/blogroll.html", matches: false},
+	}
+
+	for _, tc := range testCases {
+		received, matches := tc.rule.match(tc.urlPath)
+		if matches != tc.matches || received != tc.expected {
+			t.Errorf("%s against %s: expected %q %t, received %q %t", tc.urlPath, tc.rule.from, tc.expected, tc.matches, received, matches)
+		}
+	}
+}
+
+func everyPage(string) bool { return true }
+
+// gatedFS holds up opening html files until its gate is closed.
+type gatedFS struct {
+	fs.FS
+	gate chan struct{}
+}
+
+func (g gatedFS) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, ".html") {
+		<-g.gate
+	}
+	return g.FS.Open(name)
+}
+
+func TestRedirectLookupDoesNotWaitForAliasesToBeFound(t *testing.T) {
+	t.Parallel()
+
+	site := fstest.MapFS{
+		"new.html": {Data: []byte(`<meta name="andrew-redirect-from" content="/old.html">`)},
+	}
+	table := &redirectTable{}
+	if to, _, _ := table.lookup(site, "/old.html", DefaultMaxPartialDepth, everyPage); to != "/new.html" {
+		t.Fatalf("expected /new.html, got %q", to)
+	}
+
+	// The aliases are out of date, and finding them again is held up until the gate opens.
+	site["newer.html"] = &fstest.MapFile{Data: []byte(`<meta name="andrew-redirect-from" content="/older.html">`)}
+	gated := gatedFS{FS: site, gate: make(chan struct{})}
+	table.mu.Lock()
+	table.aliasesChecked = time.Time{}
+	table.mu.Unlock()
+
+	found := make(chan string)
+	go func() {
+		to, _, _ := table.lookup(gated, "/old.html", DefaultMaxPartialDepth, everyPage)
+		found <- to
+	}()
+
+	select {
+	case to := <-found:
+		if to != "/new.html" {
+			t.Errorf("expected the aliases already found to be used, got %q", to)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup waited for the aliases to be found again")
+	}
+
+	close(gated.gate)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if to, _, ok := table.lookup(site, "/older.html", DefaultMaxPartialDepth, everyPage); ok {
+			if to != "/newer.html" {
+				t.Errorf("expected /newer.html, got %q", to)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the aliases were never found again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// countingFS counts how many times html files are opened.
+type countingFS struct {
+	fs.FS
+	opened *atomic.Int64
+}
+
+func (c countingFS) Open(name string) (fs.File, error) {
+	if strings.HasSuffix(name, ".html") {
+		c.opened.Add(1)
+	}
+	return c.FS.Open(name)
+}
+
+func TestRedirectLookupDoesNotReadAnUnchangedSiteAgain(t *testing.T) {
+	t.Parallel()
+
+	site := countingFS{
+		FS: fstest.MapFS{
+			"new.html": {Data: []byte(`<meta name="andrew-redirect-from" content="/old.html">`)},
+		},
+		opened: &atomic.Int64{},
+	}
+	table := &redirectTable{}
+	table.lookup(site, "/old.html", DefaultMaxPartialDepth, everyPage)
+	opened := site.opened.Load()
+
+	table.mu.Lock()
+	table.aliasesChecked = time.Time{}
+	table.mu.Unlock()
+
+	table.lookup(site, "/old.html", DefaultMaxPartialDepth, everyPage)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		table.mu.RLock()
+		checked := !table.aliasesChecked.IsZero()
+		table.mu.RUnlock()
+		if checked && !table.findingAliases.Load() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the site was never checked for changes")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if received := site.opened.Load(); received != opened {
+		t.Errorf("expected the unchanged site's pages not to be read again, but they were read %d more times", received-opened)
+	}
+}
@@ -0,0 +1,90 @@
+package andrew_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// noRedirectClient hands back redirects rather than following them, so tests can look at them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func requireRedirect(t *testing.T, url string, expectedStatus int, expectedLocation string) {
+	t.Helper()
+
+	resp, err := noRedirectClient.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		t.Errorf("%s: expected status %d, received %d", url, expectedStatus, resp.StatusCode)
+	}
+
+	if location := resp.Header.Get("Location"); location != expectedLocation {
+		t.Errorf("%s: expected a redirect to %q, received %q", url, expectedLocation, location)
+	}
+}
+
+func TestServeFollowsTheRedirectsFile(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"_redirects": {Data: []byte(`/about.html /about/
+/blog/* /posts/:splat 302
+/search.html /find.html?q=all 307
+`)},
+		"about.html": {Data: []byte(`<title>still here</title>`)},
+	})
+
+	requireRedirect(t, s.BaseUrl+"/about.html", http.StatusMovedPermanently, "/about/")
+	requireRedirect(t, s.BaseUrl+"/blog/2024/hello.html?ref=feed", http.StatusFound, "/posts/2024/hello.html?ref=feed")
+	requireRedirect(t, s.BaseUrl+"/search.html?q=some", http.StatusTemporaryRedirect, "/find.html?q=all")
+}
+
+func TestServeRedirectsAPagesOldAddresses(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"posts/hello.html": {Data: []byte(`<meta name="andrew-redirect-from" content="/hello.html, /2024/hello.html">`)},
+	})
+
+	requireRedirect(t, s.BaseUrl+"/hello.html", http.StatusMovedPermanently, "/posts/hello.html")
+	requireRedirect(t, s.BaseUrl+"/2024/hello.html", http.StatusMovedPermanently, "/posts/hello.html")
+}
+
+func TestServeReloadsTheRedirectsFileWhenItChanges(t *testing.T) {
+	t.Parallel()
+
+	contentRoot := t.TempDir()
+	redirects := filepath.Join(contentRoot, "_redirects")
+
+	if err := os.WriteFile(redirects, []byte("/old.html /new.html\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestAndrewServer(t, os.DirFS(contentRoot))
+
+	requireRedirect(t, s.BaseUrl+"/old.html", http.StatusMovedPermanently, "/new.html")
+
+	if err := os.WriteFile(redirects, []byte("/old.html /newer.html 302\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	requireRedirect(t, s.BaseUrl+"/old.html", http.StatusFound, "/newer.html")
+}
+
+func TestServeRedirectsAnIndexPagesOldAddressesToItsDirectory(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"new blog/index.html": {Data: []byte(`<meta name="andrew-redirect-from" content="/old-blog/">`)},
+	})
+
+	requireRedirect(t, s.BaseUrl+"/old-blog/", http.StatusMovedPermanently, "/new%20blog/")
+}
@@ -0,0 +1,175 @@
+package andrew
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// RenderError is a page that couldn't be rendered because of a mistake in one of its
+// templates: the page itself, or one of its partials. It says where the mistake is, so that
+// whoever made it can find it.
+// A page's line numbers count lines in the page as it is after its partials and layout are
+// put into it; Excerpt shows those lines.
+type RenderError struct {
+	File      string // The page or partial the mistake is in.
+	Line      int
+	Column    int    // 0 when the mistake is found while parsing, which only knows the line.
+	Directive string // The {{ }} the mistake is in, or the whole line if there's no telling which.
+	Excerpt   string // The lines around the mistake, numbered.
+	Executing bool   // Whether the template parsed and the mistake was found executing it.
+	Err       error
+}
+
+func (e *RenderError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Column > 0 {
+		location += ":" + strconv.Itoa(e.Column)
+	}
+
+	if e.Directive == "" {
+		return fmt.Sprintf("%s: %s", location, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, e.Directive, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// kind labels the render errors metric.
+func (e *RenderError) kind() string {
+	if e.Executing {
+		return "execute"
+	}
+
+	return "parse"
+}
+
+// templateActionFinder finds the {{ }} actions on a line of a template.
+var templateActionFinder = regexp.MustCompile(`{{.*?}}`)
+
+// excerptLines is how many lines either side of a mistake a RenderError's Excerpt shows.
+const excerptLines = 2
+
+// newRenderError turns an error from parsing or executing the template called name, whose
+// source is source, into a RenderError. text/template puts the location at the front of its
+// messages, as "template: name:line:column: ...", so that's where it's read from.
+func newRenderError(name string, source string, err error) *RenderError {
+	renderErr := &RenderError{File: name, Err: err}
+
+	var execErr template.ExecError
+	renderErr.Executing = errors.As(err, &execErr)
+
+	message, found := strings.CutPrefix(err.Error(), "template: "+name+":")
+	if !found {
+		return renderErr
+	}
+
+	location, reason, _ := strings.Cut(message, " ")
+	parts := strings.Split(strings.TrimSuffix(location, ":"), ":")
+
+	renderErr.Line, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		renderErr.Column, _ = strconv.Atoi(parts[1])
+	}
+	renderErr.Err = errors.New(reason)
+
+	lines := strings.Split(source, "\n")
+	if renderErr.Line < 1 || renderErr.Line > len(lines) {
+		return renderErr
+	}
+
+	renderErr.Directive = directiveAt(lines[renderErr.Line-1], renderErr.Column)
+	renderErr.Excerpt = excerpt(lines, renderErr.Line)
+
+	return renderErr
+}
+
+// directiveAt picks out the action on line that column falls in. Without a column, it's the
+// line's first action; without any action, it's the line itself.
+func directiveAt(line string, column int) string {
+	actions := templateActionFinder.FindAllStringIndex(line, -1)
+
+	for _, action := range actions {
+		if column == 0 || (column-1 >= action[0] && column-1 < action[1]) {
+			return line[action[0]:action[1]]
+		}
+	}
+
+	return strings.TrimSpace(line)
+}
+
+// excerpt numbers the lines around the line numbered at, counting from 1.
+func excerpt(lines []string, at int) string {
+	var b strings.Builder
+
+	for n := max(1, at-excerptLines); n <= min(len(lines), at+excerptLines); n++ {
+		marker := " "
+		if n == at {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %4d | %s\n", marker, n, lines[n-1])
+	}
+
+	return b.String()
+}
+
+// serveRenderError answers a request for a page that couldn't be rendered. It's logged here,
+// once, with everything there is to know about where the mistake is. In dev mode the visitor
+// is shown all of that too; otherwise they get the same 500 as any other failure, because
+// the details of a site's templates are nobody else's business.
+func (a *Server) serveRenderError(w http.ResponseWriter, r *http.Request, pagePath string, renderErr *RenderError) {
+	slog.Error("page could not be rendered",
+		"path", r.URL.Path,
+		"file", renderErr.File,
+		"line", renderErr.Line,
+		"column", renderErr.Column,
+		"directive", renderErr.Directive,
+		"error", renderErr.Err,
+	)
+
+	renderErrorsCounter.WithLabelValues(a.siteName, renderErr.kind()).Inc()
+	allRequestsErrorsAggregatedCounter.WithLabelValues(a.siteName, "Failed Page", strconv.Itoa(http.StatusInternalServerError)).Inc()
+
+	if !a.DevMode {
+		a.serveError(w, pagePath, renderErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	writeRenderErrorPage(w, renderErr)
+}
+
+// writeRenderErrorPage is the page dev mode shows in place of a page that couldn't be rendered.
+func writeRenderErrorPage(w io.Writer, renderErr *RenderError) {
+	fmt.Fprint(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>500 render error</title>\n</head>\n<body>\n")
+	fmt.Fprintf(w, "<h1>%s couldn't be rendered</h1>\n", html.EscapeString(renderErr.File))
+	fmt.Fprintf(w, "<p class=\"andrew-render-error\">%s</p>\n", html.EscapeString(renderErr.Err.Error()))
+	fmt.Fprint(w, "<dl>\n")
+	fmt.Fprintf(w, "<dt>file</dt><dd>%s</dd>\n", html.EscapeString(renderErr.File))
+	fmt.Fprintf(w, "<dt>line</dt><dd>%d</dd>\n", renderErr.Line)
+	if renderErr.Column > 0 {
+		fmt.Fprintf(w, "<dt>column</dt><dd>%d</dd>\n", renderErr.Column)
+	}
+	if renderErr.Directive != "" {
+		fmt.Fprintf(w, "<dt>directive</dt><dd><code>%s</code></dd>\n", html.EscapeString(renderErr.Directive))
+	}
+	fmt.Fprint(w, "</dl>\n")
+	if renderErr.Excerpt != "" {
+		fmt.Fprintf(w, "<pre>%s</pre>\n", html.EscapeString(renderErr.Excerpt))
+	}
+	fmt.Fprint(w, "<p>You're seeing this because Andrew is running with --dev.</p>\n</body>\n</html>\n")
+}
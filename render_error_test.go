@@ -0,0 +1,124 @@
+package andrew_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/playtechnique/andrew"
+)
+
+func TestTemplateParseErrorInAPageIsARenderError(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"index.html": {Data: []byte("<title>home</title>\n<h1>{{ .Page.Title }</h1>\n")},
+	}}
+
+	_, err := server.NewPage("index.html")
+
+	var renderErr *andrew.RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("expected a RenderError, received %v", err)
+	}
+
+	if renderErr.File != "index.html" || renderErr.Line != 2 || renderErr.Executing {
+		t.Errorf("expected a parse error on line 2 of index.html, received %+v", renderErr)
+	}
+
+	if renderErr.Directive != "<h1>{{ .Page.Title }</h1>" {
+		t.Errorf("expected the line as the directive, received %q", renderErr.Directive)
+	}
+}
+
+func TestTemplateExecutionErrorInAPartialIsARenderError(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"index.html":                    {Data: []byte(`{{ .AndrewPartialFileFooter.html }}`)},
+		".AndrewPartialFileFooter.html": {Data: []byte("<footer>\n{{ .Site.BaseUrl }} {{ .Page.Nope }}\n</footer>\n")},
+	}}
+
+	_, err := server.NewPage("index.html")
+
+	var renderErr *andrew.RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("expected a RenderError, received %v", err)
+	}
+
+	expected := andrew.RenderError{
+		File:      ".AndrewPartialFileFooter.html",
+		Line:      2,
+		Column:    28,
+		Directive: "{{ .Page.Nope }}",
+		Excerpt:   "     1 | <footer>\n>    2 | {{ .Site.BaseUrl }} {{ .Page.Nope }}\n     3 | </footer>\n     4 | \n",
+		Executing: true,
+	}
+
+	if diff := cmp.Diff(expected, *renderErr, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Err" }, cmp.Ignore())); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestRenderErrorIsAPlain500OutsideDevMode(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte(`{{ .Page.Title `)},
+	})
+
+	resp, err := http.Get(s.BaseUrl + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected a 500, received %q", resp.Status)
+	}
+
+	if string(body) != "500 something went wrong" {
+		t.Errorf("expected the generic error, received %q", body)
+	}
+}
+
+func TestRenderErrorShowsTheDetailsInDevMode(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte("<p>fine</p>\n<p>{{ .Page.Title </p>\n")},
+	})
+	s.DevMode = true
+
+	resp, err := http.Get(s.BaseUrl + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected a 500, received %q", resp.Status)
+	}
+
+	for _, expected := range []string{
+		"<h1>index.html couldn't be rendered</h1>",
+		"<dt>line</dt><dd>2</dd>",
+		"&lt;p&gt;{{ .Page.Title &lt;/p&gt;",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected %q in %q", expected, body)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package andrew
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ServeRobotsTxt serves the robots.txt in the root of SiteFiles if there is one, because a
+// hand-written robots.txt is the author's final word. Otherwise it generates one.
+func (a Server) ServeRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	robots, err := fs.ReadFile(a.SiteFiles, "robots.txt")
+	if errors.Is(err, fs.ErrNotExist) {
+		robots, err = generateRobotsTxt(a.listedFiles(""), a.BaseUrl, a.RobotsInfo, a.partialDepth())
+	}
+
+	if err != nil {
+		message, status := CheckPageErrors(err)
+		w.WriteHeader(status)
+		fmt.Fprint(w, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	// The response is already on the wire, so there is no status left to set. A client that
+	// hangs up mid-write is routine rather than exceptional, so log it and move on.
+	if _, err := w.Write(robots); err != nil {
+		slog.Info("could not finish writing robots.txt", "error", err)
+	}
+}
+
+// GenerateRobotsTxt builds a robots.txt for the site. It has up to three parts:
+//  1. A group for each blocked crawler, asking it to stay out of the whole site.
+//  2. A group for every other crawler, asking it to stay out of the configured paths and
+//     of every page that is a draft or has <meta name="robots" content="noindex">.
+//  3. The Sitemap line, which needs the baseUrl, so it's the part a hand-written
+//     robots.txt has trouble keeping right.
+//
+// See https://www.rfc-editor.org/rfc/rfc9309.html for the format.
+func GenerateRobotsTxt(f fs.FS, baseUrl string, robots RobotsInfo) ([]byte, error) {
+	return generateRobotsTxt(f, baseUrl, robots, DefaultMaxPartialDepth)
+}
+
+// generateRobotsTxt is GenerateRobotsTxt for a site whose partials can be nested
+// maxPartialDepth deep.
+func generateRobotsTxt(f fs.FS, baseUrl string, robots RobotsInfo, maxPartialDepth int) ([]byte, error) {
+	buff := new(bytes.Buffer)
+
+	for _, crawler := range robots.BlockedCrawlers {
+		fmt.Fprintf(buff, "User-agent: %s\nDisallow: /\n\n", crawler)
+	}
+
+	disallowed := []string{}
+	for _, p := range robots.DisallowPaths {
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		disallowed = append(disallowed, p)
+	}
+
+	err := eachPage(f, ".", true, maxPartialDepth, func(page Page) {
+		if isDraft(page.Meta) || isNoIndex(page.Meta) {
+			disallowed = append(disallowed, robotsPathFor(page.UrlPath))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprint(buff, "User-agent: *\n")
+
+	// An empty Disallow allows everything. A group needs at least one rule, so this is how
+	// to say "nothing to add".
+	if len(disallowed) == 0 {
+		fmt.Fprint(buff, "Disallow:\n")
+	}
+
+	for _, p := range disallowed {
+		fmt.Fprintf(buff, "Disallow: %s\n", p)
+	}
+
+	fmt.Fprintf(buff, "\nSitemap: %s/sitemap.xml\n", baseUrl)
+
+	return buff.Bytes(), nil
+}
+
+// robotsPathFor turns a page into the rule that keeps crawlers away from just that page.
+// Rules match by prefix, so an index page is anchored with $ at the end of its directory;
+// otherwise it would block every page beneath it too.
+func robotsPathFor(pagePath string) string {
+	if pagePath == "index.html" || strings.HasSuffix(pagePath, "/index.html") {
+		return escapeUrlPath("/"+strings.TrimSuffix(pagePath, "index.html")) + "$"
+	}
+
+	return escapeUrlPath("/" + pagePath)
+}
@@ -0,0 +1,128 @@
+package andrew_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/playtechnique/andrew"
+)
+
+func TestGenerateRobotsTxtPointsAtTheSitemapAndAllowsEverythingByDefault(t *testing.T) {
+	t.Parallel()
+
+	expected := `User-agent: *
+Disallow:
+
+Sitemap: http://localhost:8080/sitemap.xml
+`
+
+	robots, err := andrew.GenerateRobotsTxt(fstest.MapFS{"index.html": {}}, "http://localhost:8080", andrew.RobotsInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(expected, string(robots)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestGenerateRobotsTxtDisallowsPagesAndConfiguredPaths(t *testing.T) {
+	t.Parallel()
+
+	expected := `User-agent: GPTBot
+Disallow: /
+
+User-agent: CCBot
+Disallow: /
+
+User-agent: *
+Disallow: /private/
+Disallow: /admin
+Disallow: /blog/$
+Disallow: /blog/wip.html
+Disallow: /thanks.html
+
+Sitemap: http://localhost:8080/sitemap.xml
+`
+
+	testFs := fstest.MapFS{
+		"index.html":      {},
+		"page.html":       {},
+		"thanks.html":     {Data: []byte(`<meta name="robots" content="noindex">`)},
+		"blog/index.html": {Data: []byte(`<meta name="robots" content="noindex, nofollow">`)},
+		"blog/wip.html":   {Data: []byte(`<meta name="andrew-draft" content="true">`)},
+	}
+
+	robotsInfo := andrew.RobotsInfo{
+		DisallowPaths:   []string{"/private/", "admin"},
+		BlockedCrawlers: []string{"GPTBot", "CCBot"},
+	}
+
+	robots, err := andrew.GenerateRobotsTxt(testFs, "http://localhost:8080", robotsInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(expected, string(robots)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestServeRobotsTxtPrefersARobotsTxtInTheContentRoot(t *testing.T) {
+	t.Parallel()
+
+	handWritten := "User-agent: *\nDisallow: /secret/\n"
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"robots.txt": {Data: []byte(handWritten)},
+	})
+
+	resp, err := http.Get(s.BaseUrl + "/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %q", resp.Status)
+	}
+
+	if diff := cmp.Diff(handWritten, string(received)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestServeRobotsTxtGeneratesOneWhenThereIsNoRobotsTxt(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{"index.html": {}})
+
+	resp, err := http.Get(s.BaseUrl + "/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	received, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Sitemap: " + s.BaseUrl + "/sitemap.xml\n"
+	if !strings.HasSuffix(string(received), want) {
+		t.Errorf("expected robots.txt to end with %q, got:\n%s", want, received)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", contentType)
+	}
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func (a Server) ServeRssFeed(w http.ResponseWriter, r *http.Request) {
-	rss, err := GenerateRssFeed(a.SiteFiles, a.BaseUrl, a.RssInfo)
+	rss, err := generateRssFeed(a.listedFiles(""), a.BaseUrl, a.RssInfo, a.partialDepth())
 	if err != nil {
 		message, status := CheckPageErrors(err)
 		w.WriteHeader(status)
@@ -42,6 +42,11 @@ func (a Server) ServeRssFeed(w http.ResponseWriter, r *http.Request) {
 // 2. your baseURl, which is interpolated into the rss feed.
 // 3. an RssInfo structure, which contains some information that is needed by your RSS feed.
 func GenerateRssFeed(f fs.FS, baseUrl string, rss RssInfo) ([]byte, error) {
+	return generateRssFeed(f, baseUrl, rss, DefaultMaxPartialDepth)
+}
+
+// generateRssFeed is GenerateRssFeed for a site whose partials can be nested maxPartialDepth deep.
+func generateRssFeed(f fs.FS, baseUrl string, rss RssInfo, maxPartialDepth int) ([]byte, error) {
 	buff := new(bytes.Buffer)
 	rssUrl := baseUrl + "/rss.xml"
 
@@ -49,32 +54,56 @@ func GenerateRssFeed(f fs.FS, baseUrl string, rss RssInfo) ([]byte, error) {
 		header = `<?xml version="1.0"?>
 <rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
 <channel>
+`
+		// A feed with episodes in it is a podcast, and podcast apps read the itunes and
+		// podcasting 2.0 elements, which need their namespaces declared up front.
+		podcastHeader = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel>
 `
 		footer = `</channel>
 </rss>
 `
 	)
-	pages, err := pagesInDir(f, rss.Dir)
+	pages, err := pagesInDir(f, rss.Dir, maxPartialDepth)
 	if err != nil {
 		return nil, err
 	}
 
 	pages = SortPagesByDate(pages)
 
-	fmt.Fprint(buff, header)
+	episodes := make([]*podcastEpisode, len(pages))
+	isPodcast := false
+	for i, page := range pages {
+		episodes[i] = newPodcastEpisode(f, baseUrl, page)
+		if episodes[i] != nil {
+			isPodcast = true
+		}
+	}
+
+	if isPodcast {
+		fmt.Fprint(buff, podcastHeader)
+	} else {
+		fmt.Fprint(buff, header)
+	}
 
 	fmt.Fprintf(buff, "\t<title>%s</title>\n"+
 		"\t<link>%s</link>\n"+
 		"\t<description>%s</description>\n"+
 		"\t<generator>Andrew</generator>\n", rss.Title, baseUrl, rss.Description)
 
-	for _, page := range pages {
+	for i, page := range pages {
 		fmt.Fprintf(buff, "\t<item>\n"+
 			"\t\t<title>%s</title>\n"+
 			"\t\t<link>%s</link>\n"+
 			"\t\t<pubDate>%s</pubDate>\n"+
-			"\t\t<source url=\"%s\">%s</source>\n"+
-			"\t</item>\n", page.Title, baseUrl+"/"+page.UrlPath, page.PublishTime.Format(time.RFC1123Z), rssUrl, rss.Title)
+			"\t\t<source url=\"%s\">%s</source>\n", page.Title, baseUrl+"/"+escapeUrlPath(page.UrlPath), page.PublishTime.Format(time.RFC1123Z), rssUrl, rss.Title)
+
+		if episodes[i] != nil {
+			episodes[i].write(buff)
+		}
+
+		fmt.Fprint(buff, "\t</item>\n")
 	}
 
 	fmt.Fprint(buff, footer)
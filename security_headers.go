@@ -0,0 +1,284 @@
+package andrew
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// cspReportPath is where browsers send reports of what a Content-Security-Policy blocked,
+// or would have blocked in report-only mode.
+const cspReportPath = "/csp-report"
+
+const (
+	// maxCSPReportSize is the most of a CSP report Andrew will read. Real reports are a few
+	// hundred bytes.
+	maxCSPReportSize = 8 * 1024
+
+	// maxCSPReportsLogged is how many CSP reports are logged a minute. Anyone can send them, so
+	// the rest are only counted, to keep them from flooding the log.
+	maxCSPReportsLogged = 60
+
+	// maxCSPReportFieldLength is how much of each of a report's fields is logged.
+	maxCSPReportFieldLength = 200
+)
+
+// setSecurityHeaders sets the security headers on a response, when they're on, and
+// Strict-Transport-Security on an https response, when there's one to send. The
+// Content-Security-Policy set here is the policy as it was given; pages have it replaced by
+// one with their inline scripts and styles added, in serve.
+func (a *Server) setSecurityHeaders(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+
+	// Browsers ignore Strict-Transport-Security that arrives over http.
+	if r.TLS != nil && a.SecurityInfo.HSTS != "" {
+		header.Set("Strict-Transport-Security", a.SecurityInfo.HSTS)
+	}
+
+	if !a.SecurityInfo.Headers {
+		return
+	}
+
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	header.Set("X-Frame-Options", "SAMEORIGIN")
+
+	if a.SecurityInfo.CSP != "" {
+		header.Set(a.cspHeaderName(), a.withCSPReportURI(a.SecurityInfo.CSP))
+	}
+}
+
+// setPageContentSecurityPolicy sets the Content-Security-Policy for an html page, with
+// hashes for the page's inline <script> and <style> blocks, so that the page's own inline
+// code keeps working under a policy that doesn't allow inline code in general.
+func (a *Server) setPageContentSecurityPolicy(w http.ResponseWriter, content string) {
+	if !a.SecurityInfo.Headers || a.SecurityInfo.CSP == "" {
+		return
+	}
+
+	scripts, styles := inlineHashes([]byte(content))
+	policy := withCSPHashes(a.SecurityInfo.CSP, "script-src", scripts)
+	policy = withCSPHashes(policy, "style-src", styles)
+
+	w.Header().Set(a.cspHeaderName(), a.withCSPReportURI(policy))
+}
+
+func (a *Server) cspHeaderName() string {
+	if a.SecurityInfo.CSPReportOnly {
+		return "Content-Security-Policy-Report-Only"
+	}
+
+	return "Content-Security-Policy"
+}
+
+// inlineHashes finds the CSP source expressions, like 'sha256-...', for the inline scripts
+// and styles in an html page. Scripts with a src aren't inline, and are left to the policy.
+func inlineHashes(content []byte) (scripts []string, styles []string) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			if n.Data == "script" && slices.ContainsFunc(n.Attr, func(attr html.Attribute) bool { return attr.Key == "src" }) {
+				return
+			}
+
+			var text strings.Builder
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				text.WriteString(c.Data)
+			}
+
+			sum := sha256.Sum256([]byte(text.String()))
+			hash := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+
+			if n.Data == "script" && !slices.Contains(scripts, hash) {
+				scripts = append(scripts, hash)
+			} else if n.Data == "style" && !slices.Contains(styles, hash) {
+				styles = append(styles, hash)
+			}
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return scripts, styles
+}
+
+// withCSPHashes adds hashes to one of a policy's directives. A policy without the directive
+// falls back to its default-src for it, so the directive is added as a copy of default-src
+// with the hashes on the end; without a default-src either, inline code is already allowed.
+// A directive that allows 'unsafe-inline', or is 'none', is left alone: hashes would stop the
+// first from working, and override the second.
+func withCSPHashes(policy string, directive string, hashes []string) string {
+	if len(hashes) == 0 {
+		return policy
+	}
+
+	directives := strings.Split(policy, ";")
+	fallback := -1
+
+	for i, d := range directives {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case directive:
+			if slices.Contains(fields, "'unsafe-inline'") || slices.Contains(fields, "'none'") {
+				return policy
+			}
+			directives[i] = strings.Join(append(fields, hashes...), " ")
+			return joinCSP(directives)
+		case "default-src":
+			fallback = i
+		}
+	}
+
+	if fallback == -1 {
+		return policy
+	}
+
+	sources := strings.Fields(directives[fallback])[1:]
+	if slices.Contains(sources, "'unsafe-inline'") {
+		return policy
+	}
+	if slices.Contains(sources, "'none'") {
+		sources = nil
+	}
+
+	added := append(append([]string{directive}, sources...), hashes...)
+
+	return joinCSP(append(directives, strings.Join(added, " ")))
+}
+
+// withCSPReportURI points a report-only policy's reports at Andrew's report endpoint. An
+// enforced policy is sent as it was given, reporting wherever it says to, if anywhere.
+func (a *Server) withCSPReportURI(policy string) string {
+	if !a.SecurityInfo.CSPReportOnly {
+		return policy
+	}
+
+	return withCSPReportURI(policy)
+}
+
+// withCSPReportURI points a policy's reports at Andrew's report endpoint, unless the policy
+// already sends them somewhere.
+func withCSPReportURI(policy string) string {
+	if strings.Contains(policy, "report-uri") || strings.Contains(policy, "report-to") {
+		return policy
+	}
+
+	return joinCSP(append(strings.Split(policy, ";"), "report-uri "+cspReportPath))
+}
+
+func joinCSP(directives []string) string {
+	kept := []string{}
+	for _, d := range directives {
+		if d = strings.TrimSpace(d); d != "" {
+			kept = append(kept, d)
+		}
+	}
+
+	return strings.Join(kept, "; ")
+}
+
+// ServeCSPReport logs a summary of the violations browsers report against a report-only
+// Content-Security-Policy, which is how to find out what a policy would break before
+// enforcing it. Only so many are logged a minute; the rest are counted, and the count is
+// logged with the next one that is.
+func (a Server) ServeCSPReport(w http.ResponseWriter, r *http.Request) {
+	if !a.SecurityInfo.Headers || a.SecurityInfo.CSP == "" || !a.SecurityInfo.CSPReportOnly {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var report struct {
+		Report map[string]any `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil || report.Report == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if ok, dropped := a.shared().cspReports.allow(time.Now()); ok {
+		slog.Info("content security policy violation",
+			"document_uri", reportField(report.Report, "document-uri"),
+			"violated_directive", reportField(report.Report, "violated-directive"),
+			"blocked_uri", reportField(report.Report, "blocked-uri"),
+			"reports_not_logged", dropped,
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reportField is one of a CSP report's fields as a string, cut short enough to log.
+func reportField(report map[string]any, name string) string {
+	value, _ := report[name].(string)
+	if len(value) > maxCSPReportFieldLength {
+		value = strings.ToValidUTF8(value[:maxCSPReportFieldLength], "") + "..."
+	}
+
+	return value
+}
+
+// reportLimiter lets maxCSPReportsLogged reports be logged a minute, and counts the ones that
+// aren't. Its zero value is ready to use.
+type reportLimiter struct {
+	mu      sync.Mutex
+	minute  time.Time
+	logged  int
+	dropped int
+}
+
+// allow reports whether a report that arrived at now can be logged, and if so how many
+// weren't since the last one that was.
+func (l *reportLimiter) allow(now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if minute := now.Truncate(time.Minute); !minute.Equal(l.minute) {
+		l.minute, l.logged = minute, 0
+	}
+
+	if l.logged >= maxCSPReportsLogged {
+		l.dropped++
+		return false, 0
+	}
+
+	l.logged++
+	dropped := l.dropped
+	l.dropped = 0
+
+	return true, dropped
+}
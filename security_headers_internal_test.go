@@ -0,0 +1,140 @@
+package andrew
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func cspHash(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+func TestInlineHashes(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`<html><head>
+<style>body { color: red; }</style>
+<script src="/app.js"></script>
+</head><body>
+<script>console.log("hi")</script>
+<script>console.log("hi")</script>
+</body></html>`)
+
+	scripts, styles := inlineHashes(content)
+
+	if diff := cmp.Diff([]string{cspHash(`console.log("hi")`)}, scripts); diff != "" {
+		t.Error(diff)
+	}
+
+	if diff := cmp.Diff([]string{cspHash(`body { color: red; }`)}, styles); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestWithCSPHashes(t *testing.T) {
+	t.Parallel()
+
+	hashes := []string{"'sha256-abc'"}
+
+	testCases := []struct {
+		policy   string
+		expected string
+	}{
+		{
+			policy:   "default-src 'self'; script-src 'self' https://cdn.example.com",
+			expected: "default-src 'self'; script-src 'self' https://cdn.example.com 'sha256-abc'",
+		},
+		{
+			policy:   "default-src 'self'; object-src 'none'",
+			expected: "default-src 'self'; object-src 'none'; script-src 'self' 'sha256-abc'",
+		},
+		{
+			policy:   "default-src 'none'",
+			expected: "default-src 'none'; script-src 'sha256-abc'",
+		},
+		{
+			policy:   "default-src 'self'; script-src 'self' 'unsafe-inline'",
+			expected: "default-src 'self'; script-src 'self' 'unsafe-inline'",
+		},
+		{
+			policy:   "img-src 'self'",
+			expected: "img-src 'self'",
+		},
+	}
+
+	for _, tc := range testCases {
+		if received := withCSPHashes(tc.policy, "script-src", hashes); received != tc.expected {
+			t.Errorf("%q: expected %q, received %q", tc.policy, tc.expected, received)
+		}
+	}
+}
+
+func TestWithCSPReportURI(t *testing.T) {
+	t.Parallel()
+
+	if received := withCSPReportURI("default-src 'self';"); received != "default-src 'self'; report-uri /csp-report" {
+		t.Errorf("expected the report endpoint to be added, received %q", received)
+	}
+
+	if received := withCSPReportURI("default-src 'self'; report-uri https://reports.example.com"); received != "default-src 'self'; report-uri https://reports.example.com" {
+		t.Errorf("expected the policy's own report-uri to be kept, received %q", received)
+	}
+}
+
+func TestServerOnlyPointsReportOnlyPoliciesAtTheReportEndpoint(t *testing.T) {
+	t.Parallel()
+
+	enforced := &Server{SecurityInfo: SecurityInfo{Headers: true, CSP: "default-src 'self'"}}
+	if received := enforced.withCSPReportURI("default-src 'self'"); received != "default-src 'self'" {
+		t.Errorf("expected an enforced policy to be left alone, received %q", received)
+	}
+
+	reportOnly := &Server{SecurityInfo: SecurityInfo{Headers: true, CSP: "default-src 'self'", CSPReportOnly: true}}
+	if received := reportOnly.withCSPReportURI("default-src 'self'"); received != "default-src 'self'; report-uri /csp-report" {
+		t.Errorf("expected a report-only policy to report to Andrew, received %q", received)
+	}
+}
+
+func TestReportLimiterOnlyLogsSoManyReportsAMinute(t *testing.T) {
+	t.Parallel()
+
+	limiter := &reportLimiter{}
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxCSPReportsLogged; i++ {
+		if ok, _ := limiter.allow(start); !ok {
+			t.Fatalf("expected report %d to be logged", i+1)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.allow(start.Add(30 * time.Second)); ok {
+			t.Fatal("expected a report over the limit not to be logged")
+		}
+	}
+
+	ok, dropped := limiter.allow(start.Add(time.Minute))
+	if !ok || dropped != 3 {
+		t.Errorf("expected the next minute's first report to be logged with 3 dropped, received %v and %d", ok, dropped)
+	}
+}
+
+func TestReportFieldIsCutShort(t *testing.T) {
+	t.Parallel()
+
+	report := map[string]any{"blocked-uri": strings.Repeat("a", 1000), "line-number": 12.0}
+
+	if received := reportField(report, "blocked-uri"); received != strings.Repeat("a", maxCSPReportFieldLength)+"..." {
+		t.Errorf("expected the field to be cut short, received %d bytes", len(received))
+	}
+
+	if received := reportField(report, "line-number"); received != "" {
+		t.Errorf("expected a field that isn't a string to be left out, received %q", received)
+	}
+}
@@ -0,0 +1,88 @@
+package andrew_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/playtechnique/andrew"
+)
+
+func TestServeSendsSecurityHeaders(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte(`<html><head><style>p { margin: 0; }</style></head><body><p>hi</p></body></html>`)},
+		"style.css":  {Data: []byte(`body {}`)},
+	})
+	s.SecurityInfo = andrew.SecurityInfo{Headers: true, CSP: "default-src 'self'", HSTS: andrew.DefaultHSTS}
+
+	requireHeader(t, s.BaseUrl+"/style.css", "X-Content-Type-Options", "nosniff")
+	requireHeader(t, s.BaseUrl+"/style.css", "Referrer-Policy", "strict-origin-when-cross-origin")
+	requireHeader(t, s.BaseUrl+"/style.css", "X-Frame-Options", "SAMEORIGIN")
+	requireHeader(t, s.BaseUrl+"/style.css", "Content-Security-Policy", "default-src 'self'")
+
+	// Over http, Strict-Transport-Security would only be ignored.
+	requireHeader(t, s.BaseUrl+"/style.css", "Strict-Transport-Security", "")
+
+	requireHeader(t, s.BaseUrl+"/index.html", "Content-Security-Policy",
+		"default-src 'self'; style-src 'self' 'sha256-ZMIWxFqsI8wXm9tNjoL6Cyi5nRfO7zhfynHh3P3TR9s='")
+
+	// Reports are only taken in report-only mode.
+	resp, err := http.Post(s.BaseUrl+"/csp-report", "application/csp-report", strings.NewReader(`{"csp-report": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 for a report against an enforced policy, received %d", resp.StatusCode)
+	}
+}
+
+func TestServeSendsNoSecurityHeadersWhenTheyAreOff(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte(`<p>hi</p>`)},
+	})
+
+	requireHeader(t, s.BaseUrl+"/index.html", "X-Content-Type-Options", "")
+	requireHeader(t, s.BaseUrl+"/index.html", "Content-Security-Policy", "")
+}
+
+func TestServeSendsAReportOnlyPolicyAndAcceptsReports(t *testing.T) {
+	t.Parallel()
+
+	s := newTestAndrewServer(t, fstest.MapFS{
+		"index.html": {Data: []byte(`<p>hi</p>`)},
+	})
+	s.SecurityInfo = andrew.SecurityInfo{Headers: true, CSP: "default-src 'self'", CSPReportOnly: true}
+
+	requireHeader(t, s.BaseUrl+"/index.html", "Content-Security-Policy", "")
+	requireHeader(t, s.BaseUrl+"/index.html", "Content-Security-Policy-Report-Only", "default-src 'self'; report-uri /csp-report")
+
+	report := `{"csp-report": {"document-uri": "http://example.com/", "violated-directive": "script-src", "blocked-uri": "inline"}}`
+
+	resp, err := http.Post(s.BaseUrl+"/csp-report", "application/csp-report", strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected a 204 for a report, received %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(s.BaseUrl+"/csp-report", "application/csp-report", bytes.NewReader([]byte("not json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a 400 for something that isn't a report, received %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,188 @@
+package andrew
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy is what Andrew does about symbolic links in the content root.
+type SymlinkPolicy string
+
+const (
+	// SymlinksFollow follows every link, wherever it points, like os.DirFS does.
+	SymlinksFollow SymlinkPolicy = "follow"
+	// SymlinksWithinRoot follows links that end up inside the content root, and treats the
+	// rest as though they weren't there.
+	SymlinksWithinRoot SymlinkPolicy = "within-root-only"
+	// SymlinksDeny treats every link as though it wasn't there.
+	SymlinksDeny SymlinkPolicy = "deny"
+
+	DefaultSymlinkPolicy = SymlinksWithinRoot
+)
+
+// ParseSymlinkPolicy reads a policy as it's written on the command line.
+func ParseSymlinkPolicy(policy string) (SymlinkPolicy, error) {
+	switch p := SymlinkPolicy(policy); p {
+	case SymlinksFollow, SymlinksWithinRoot, SymlinksDeny:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("--symlinks must be one of %s, %s or %s, not %q", SymlinksFollow, SymlinksWithinRoot, SymlinksDeny, policy)
+}
+
+// NewSiteFS is the content root at root as an fs.FS, with policy applied to its symbolic
+// links. Everything Andrew reads goes through it: the pages it serves, the partials and
+// layouts findPartialFile looks up, and the directories pagesInDir walks. A link the policy
+// doesn't allow is reported as not existing, so it's answered with a 404 and left out of
+// directory listings. So is a link that can't be resolved, like one that points at itself.
+//
+// Walks never go into linked directories, whatever the policy, so a link to a directory
+// above it can't send a walk round in circles. Pages in a linked directory are still served
+// if the policy allows the link.
+func NewSiteFS(root string, policy SymlinkPolicy) fs.FS {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		resolvedRoot = root
+	}
+
+	return &siteFS{root: root, resolvedRoot: resolvedRoot, fsys: os.DirFS(root), policy: policy}
+}
+
+type siteFS struct {
+	root         string
+	resolvedRoot string // root with its own links resolved, to compare resolved paths against.
+	fsys         fs.FS
+	policy       SymlinkPolicy
+}
+
+func (s *siteFS) Open(name string) (fs.File, error) {
+	if err := s.check(name); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir, ok := file.(fs.ReadDirFile); ok {
+		return &siteDir{ReadDirFile: dir, fs: s, name: name}, nil
+	}
+
+	return file, nil
+}
+
+func (s *siteFS) Stat(name string) (fs.FileInfo, error) {
+	if err := s.check(name); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return fs.Stat(s.fsys, name)
+}
+
+// check reports fs.ErrNotExist if the policy doesn't allow name, or if name is a link that
+// can't be resolved.
+func (s *siteFS) check(name string) error {
+	if !fs.ValidPath(name) {
+		return fs.ErrInvalid
+	}
+
+	fullPath := filepath.Join(s.root, filepath.FromSlash(name))
+
+	switch s.policy {
+	case SymlinksDeny:
+		if name == "." {
+			return nil
+		}
+
+		current := s.root
+		for _, segment := range strings.Split(name, "/") {
+			current = filepath.Join(current, segment)
+
+			info, err := os.Lstat(current)
+			if err != nil {
+				// Let opening it report that it's missing.
+				return nil
+			}
+
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return fs.ErrNotExist
+			}
+		}
+
+	case SymlinksWithinRoot:
+		resolved, err := filepath.EvalSymlinks(fullPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			// A link to something missing is missing, so there's no need to tell them apart.
+			return nil
+		}
+		if err != nil {
+			return fs.ErrNotExist
+		}
+
+		if !isWithin(s.resolvedRoot, resolved) {
+			return fs.ErrNotExist
+		}
+
+	default:
+		if _, err := os.Stat(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fs.ErrNotExist
+		}
+	}
+
+	return nil
+}
+
+// isWithin reports whether target is root, or somewhere under it.
+func isWithin(root string, target string) bool {
+	relative, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+
+	return relative == "." || (relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)))
+}
+
+// siteDir is a directory in a siteFS. Its listings leave out the links the policy doesn't
+// allow, and the links that can't be resolved.
+type siteDir struct {
+	fs.ReadDirFile
+	fs   *siteFS
+	name string
+}
+
+func (d *siteDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+
+		kept := []fs.DirEntry{}
+		for _, entry := range entries {
+			if entry.Type()&fs.ModeSymlink != 0 && !d.fs.allowsLink(path.Join(d.name, entry.Name())) {
+				continue
+			}
+			kept = append(kept, entry)
+		}
+
+		// A caller asking for n entries is owed at least one, or an error.
+		if n <= 0 || len(kept) > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// allowsLink reports whether the link at name can be followed: the policy allows it, and
+// there's something at the other end.
+func (s *siteFS) allowsLink(name string) bool {
+	if s.check(name) != nil {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(name)))
+
+	return err == nil
+}
@@ -0,0 +1,219 @@
+package andrew_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/playtechnique/andrew"
+)
+
+// newSymlinkedSite builds a content root next to a directory it mustn't be able to reach,
+// with links pointing inside the root, outside it, and at themselves.
+func newSymlinkedSite(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "site")
+	outside := filepath.Join(dir, "outside")
+
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(root, "index.html"):     `<title>home</title>`,
+		filepath.Join(root, "inside.html"):    `<title>inside</title>`,
+		filepath.Join(outside, "secret.html"): `<title>secret</title>`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"link-inside.html":           "inside.html",
+		"link-outside.html":          "../outside/secret.html",
+		"linked-dir":                 "../outside",
+		"loop.html":                  "loop.html",
+		"up":                         ".",
+		".AndrewPartialFileNav.html": "../outside/secret.html",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	t.Parallel()
+
+	root := newSymlinkedSite(t)
+
+	testCases := []struct {
+		policy   andrew.SymlinkPolicy
+		expected map[string]int
+	}{
+		{
+			policy: andrew.SymlinksFollow,
+			expected: map[string]int{
+				"/link-inside.html":       http.StatusOK,
+				"/link-outside.html":      http.StatusOK,
+				"/linked-dir/secret.html": http.StatusOK,
+				"/loop.html":              http.StatusNotFound,
+			},
+		},
+		{
+			policy: andrew.SymlinksWithinRoot,
+			expected: map[string]int{
+				"/link-inside.html":       http.StatusOK,
+				"/link-outside.html":      http.StatusNotFound,
+				"/linked-dir/secret.html": http.StatusNotFound,
+				"/loop.html":              http.StatusNotFound,
+				"/up/inside.html":         http.StatusOK,
+			},
+		},
+		{
+			policy: andrew.SymlinksDeny,
+			expected: map[string]int{
+				"/link-inside.html":       http.StatusNotFound,
+				"/link-outside.html":      http.StatusNotFound,
+				"/linked-dir/secret.html": http.StatusNotFound,
+				"/loop.html":              http.StatusNotFound,
+				"/up/inside.html":         http.StatusNotFound,
+				"/inside.html":            http.StatusOK,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		s := newTestAndrewServer(t, andrew.NewSiteFS(root, tc.policy))
+
+		for urlPath, status := range tc.expected {
+			resp, err := http.Get(s.BaseUrl + urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != status {
+				t.Errorf("%s %s: expected status %d, received %d", tc.policy, urlPath, status, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestSymlinkPolicyAppliesToListings(t *testing.T) {
+	t.Parallel()
+
+	root := newSymlinkedSite(t)
+
+	testCases := map[andrew.SymlinkPolicy][]string{
+		andrew.SymlinksFollow:     {"inside.html", "link-inside.html", "link-outside.html"},
+		andrew.SymlinksWithinRoot: {"inside.html", "link-inside.html"},
+		andrew.SymlinksDeny:       {"inside.html"},
+	}
+
+	for policy, expected := range testCases {
+		server := andrew.Server{SiteFiles: andrew.NewSiteFS(root, policy)}
+
+		siblings, err := server.GetSiblingsAndChildren("index.html")
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+
+		got := []string{}
+		for _, sibling := range siblings {
+			got = append(got, sibling.UrlPath)
+		}
+
+		slices.Sort(got)
+
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Errorf("%s: %s", policy, diff)
+		}
+	}
+}
+
+func TestSymlinkPolicyAppliesToPartials(t *testing.T) {
+	t.Parallel()
+
+	root := newSymlinkedSite(t)
+
+	if err := os.WriteFile(filepath.Join(root, "nav.html"), []byte(`{{ .AndrewPartialFileNav.html }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := andrew.Server{SiteFiles: andrew.NewSiteFS(root, andrew.SymlinksWithinRoot)}
+
+	page, err := server.NewPage("nav.html")
+	if err == nil && strings.Contains(page.Content, "secret") {
+		t.Errorf("expected a partial linked from outside the root not to be read, received %q", page.Content)
+	}
+}
+
+// rawGet sends requestURI exactly as it's written, which an http.Client won't do for
+// paths it thinks need cleaning or escaping.
+func rawGet(t *testing.T, address string, requestURI string) (int, string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", requestURI, address)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestServeDoesNotLeaveTheContentRoot(t *testing.T) {
+	t.Parallel()
+
+	root := newSymlinkedSite(t)
+	s := newTestAndrewServer(t, andrew.NewSiteFS(root, andrew.SymlinksWithinRoot))
+
+	for _, requestURI := range []string{
+		"/../outside/secret.html",
+		"/%2e%2e/outside/secret.html",
+		"/%2E%2E/%2e%2e/outside/secret.html",
+		"/..%2foutside%2fsecret.html",
+		"//outside/secret.html",
+		"/.//..//outside/secret.html",
+		"/index.html?/../../outside/secret.html",
+		"/inside.html?file=../outside/secret.html",
+		"/up/../../outside/secret.html",
+	} {
+		status, body := rawGet(t, s.Address, requestURI)
+
+		if status == http.StatusOK && strings.Contains(body, "secret") {
+			t.Errorf("%s: served a file from outside the content root", requestURI)
+		}
+	}
+}
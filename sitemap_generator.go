@@ -2,17 +2,78 @@ package andrew
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"html"
 	"io/fs"
 	"log/slog"
 	"net/http"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// The sitemap protocol caps a single sitemap file at 50,000 URLs and 50MB uncompressed.
+// See https://www.sitemaps.org/protocol.html#index. These are variables rather than
+// constants so the tests can split a sitemap without building a 50,000 page site.
+var (
+	siteMapMaxUrls  = 50000
+	siteMapMaxBytes = 50 * 1024 * 1024
+)
+
+// siteMapPartFinder recognises the request path of one part of a split sitemap,
+// e.g. sitemap-2.xml.
+var siteMapPartFinder = regexp.MustCompile(`^sitemap-([1-9][0-9]*)\.xml$`)
+
+// siteMapChangeFreqs are the only values the sitemap protocol allows in <changefreq>.
+var siteMapChangeFreqs = map[string]bool{
+	"always": true, "hourly": true, "daily": true, "weekly": true,
+	"monthly": true, "yearly": true, "never": true,
+}
+
+const (
+	siteMapUrlSetHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+`
+	siteMapUrlSetFooter = `</urlset>
+`
+	siteMapIndexHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+`
+	siteMapIndexFooter = `</sitemapindex>
+`
+)
+
+// siteMapPart is one <urlset> document, along with the most recent lastmod inside it so
+// the sitemap index can advertise when each part last changed.
+type siteMapPart struct {
+	content []byte
+	lastMod time.Time
+}
+
 // SiteMap
 func (a Server) ServeSiteMap(w http.ResponseWriter, r *http.Request) {
-	sitemap, err := GenerateSiteMap(a.SiteFiles, a.BaseUrl)
+	sitemap, err := generateSiteMap(a.listedFiles(""), a.BaseUrl, a.partialDepth())
+	a.writeSiteMap(w, sitemap, err)
+}
+
+// serveSiteMapPart serves sitemap-n.xml, which only exists once a site is big enough to
+// need splitting. The mux can't route a pattern like sitemap-{n}.xml, so Serve calls this.
+// It reports whether there was a part to serve; when there wasn't, sitemap-n.xml is
+// answered like any other missing file.
+func (a Server) serveSiteMapPart(w http.ResponseWriter, n int) bool {
+	sitemap, err := generateSiteMapPart(a.listedFiles(""), a.BaseUrl, n, a.partialDepth())
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+
+	a.writeSiteMap(w, sitemap, err)
+
+	return true
+}
+
+func (a Server) writeSiteMap(w http.ResponseWriter, sitemap []byte, err error) {
 	if err != nil {
 		message, status := CheckPageErrors(err)
 		w.WriteHeader(status)
@@ -30,42 +91,165 @@ func (a Server) ServeSiteMap(w http.ResponseWriter, r *http.Request) {
 }
 
 // Generates and returns a sitemap.xml.
+// A site that fits inside the protocol's limits gets a single <urlset>. A bigger site gets
+// a <sitemapindex> pointing at sitemap-1.xml, sitemap-2.xml and so on, which
+// GenerateSiteMapPart builds.
 // An error from the walk is returned rather than swallowed, so that a partial walk surfaces
 // as an http error instead of a sitemap that looks complete but silently omits pages.
 func GenerateSiteMap(f fs.FS, baseUrl string) ([]byte, error) {
-	buff := new(bytes.Buffer)
+	return generateSiteMap(f, baseUrl, DefaultMaxPartialDepth)
+}
 
-	const (
-		header = `<?xml version="1.0" encoding="UTF-8"?>
-<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
-`
-		footer = `</urlset>
-`
-	)
+// generateSiteMap is GenerateSiteMap for a site whose partials can be nested maxPartialDepth deep.
+func generateSiteMap(f fs.FS, baseUrl string, maxPartialDepth int) ([]byte, error) {
+	parts, err := siteMapParts(f, baseUrl, maxPartialDepth)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Fprint(buff, header)
+	if len(parts) == 1 {
+		return parts[0].content, nil
+	}
 
-	err := fs.WalkDir(f, ".", func(path string, dir fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	buff := new(bytes.Buffer)
 
-		if filepath.Ext(path) == ".html" {
-			// index.html
-			// foo/bar/index.html
-			path = strings.TrimSuffix(path, "index.html")
+	fmt.Fprint(buff, siteMapIndexHeader)
 
-			fmt.Fprintf(buff, "\t<url>\n\t\t<loc>%s/%s</loc>\n\t</url>\n", baseUrl, path)
+	for i, part := range parts {
+		fmt.Fprintf(buff, "\t<sitemap>\n\t\t<loc>%s/sitemap-%d.xml</loc>\n", html.EscapeString(baseUrl), i+1)
+		if !part.lastMod.IsZero() {
+			fmt.Fprintf(buff, "\t\t<lastmod>%s</lastmod>\n", part.lastMod.Format(time.RFC3339))
 		}
+		fmt.Fprint(buff, "\t</sitemap>\n")
+	}
+
+	fmt.Fprint(buff, siteMapIndexFooter)
+
+	return buff.Bytes(), nil
+}
+
+// GenerateSiteMapPart returns sitemap-n.xml, counting from 1. Asking for a part that doesn't
+// exist, including any part at all of a site that fits in one sitemap, is fs.ErrNotExist.
+func GenerateSiteMapPart(f fs.FS, baseUrl string, n int) ([]byte, error) {
+	return generateSiteMapPart(f, baseUrl, n, DefaultMaxPartialDepth)
+}
+
+// generateSiteMapPart is GenerateSiteMapPart for a site whose partials can be nested
+// maxPartialDepth deep.
+func generateSiteMapPart(f fs.FS, baseUrl string, n int, maxPartialDepth int) ([]byte, error) {
+	parts, err := siteMapParts(f, baseUrl, maxPartialDepth)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
-	})
+	if len(parts) == 1 || n < 1 || n > len(parts) {
+		return nil, fs.ErrNotExist
+	}
+
+	return parts[n-1].content, nil
+}
 
+// siteMapParts renders a <url> for every page a search engine should know about, and packs
+// them into as few <urlset> documents as the protocol's limits allow. There is always at
+// least one part, even for an empty site.
+func siteMapParts(f fs.FS, baseUrl string, maxPartialDepth int) ([]siteMapPart, error) {
+	pages, err := walkPages(f, ".", true, maxPartialDepth)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Fprint(buff, footer)
+	parts := []siteMapPart{}
+	current := new(bytes.Buffer)
+	fmt.Fprint(current, siteMapUrlSetHeader)
+	currentUrls := 0
+	var currentLastMod time.Time
 
-	return buff.Bytes(), nil
+	for _, page := range pages {
+		if isNoIndex(page.Meta) {
+			continue
+		}
+
+		entry := siteMapEntry(baseUrl, page)
+
+		full := currentUrls == siteMapMaxUrls ||
+			current.Len()+len(entry)+len(siteMapUrlSetFooter) > siteMapMaxBytes
+
+		if full && currentUrls > 0 {
+			fmt.Fprint(current, siteMapUrlSetFooter)
+			parts = append(parts, siteMapPart{content: current.Bytes(), lastMod: currentLastMod})
+
+			current = new(bytes.Buffer)
+			fmt.Fprint(current, siteMapUrlSetHeader)
+			currentUrls = 0
+			currentLastMod = time.Time{}
+		}
+
+		current.WriteString(entry)
+		currentUrls++
+		if page.PublishTime.After(currentLastMod) {
+			currentLastMod = page.PublishTime
+		}
+	}
+
+	fmt.Fprint(current, siteMapUrlSetFooter)
+	parts = append(parts, siteMapPart{content: current.Bytes(), lastMod: currentLastMod})
+
+	return parts, nil
+}
+
+// siteMapEntry renders one <url>. Index pages are listed as their directory, because that's
+// the address people link to. <lastmod> comes from the page's publish time; <changefreq> and
+// <priority> are optional and come from meta elements:
+//
+//	<meta name="andrew-sitemap-changefreq" content="weekly">
+//	<meta name="andrew-sitemap-priority" content="0.8">
+//
+// Values the protocol doesn't allow are left out rather than passed on to search engines.
+func siteMapEntry(baseUrl string, page Page) string {
+	// index.html
+	// foo/bar/index.html
+	loc := strings.TrimSuffix(page.UrlPath, "index.html")
+
+	// escapeUrlPath leaves the characters a path may hold as they are, & among them, and
+	// those still need escaping in xml.
+	entry := fmt.Sprintf("\t<url>\n\t\t<loc>%s</loc>\n", html.EscapeString(baseUrl+"/"+escapeUrlPath(loc)))
+
+	if !page.PublishTime.IsZero() {
+		entry += fmt.Sprintf("\t\t<lastmod>%s</lastmod>\n", page.PublishTime.Format(time.RFC3339))
+	}
+
+	if changeFreq, ok := page.Meta["andrew-sitemap-changefreq"]; ok {
+		changeFreq = strings.ToLower(strings.TrimSpace(changeFreq))
+		if siteMapChangeFreqs[changeFreq] {
+			entry += fmt.Sprintf("\t\t<changefreq>%s</changefreq>\n", changeFreq)
+		} else {
+			slog.Debug("siteMapEntry", "ignoring changefreq", changeFreq, "path", page.UrlPath)
+		}
+	}
+
+	if priority, ok := page.Meta["andrew-sitemap-priority"]; ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(priority), 64)
+		if err == nil && p >= 0 && p <= 1 {
+			entry += fmt.Sprintf("\t\t<priority>%s</priority>\n", strconv.FormatFloat(p, 'f', -1, 64))
+		} else {
+			slog.Debug("siteMapEntry", "ignoring priority", priority, "path", page.UrlPath)
+		}
+	}
+
+	entry += "\t</url>\n"
+
+	return entry
+}
+
+// isNoIndex reports whether a page has asked search engines to leave it out, with
+// <meta name="robots" content="noindex">. The content can hold several comma separated
+// directives, in any case.
+func isNoIndex(meta map[string]string) bool {
+	for _, directive := range strings.Split(meta["robots"], ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "noindex") {
+			return true
+		}
+	}
+
+	return false
 }
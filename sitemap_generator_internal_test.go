@@ -0,0 +1,116 @@
+package andrew
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestGenerateSiteMapSplitsIntoAnIndexWhenThereAreTooManyUrls shrinks the protocol's 50,000
+// URL limit to two so the split can be seen with a handful of pages.
+// Not parallel: it changes a package-level limit.
+func TestGenerateSiteMapSplitsIntoAnIndexWhenThereAreTooManyUrls(t *testing.T) {
+	original := siteMapMaxUrls
+	siteMapMaxUrls = 2
+	t.Cleanup(func() { siteMapMaxUrls = original })
+
+	testFs := fstest.MapFS{
+		"a.html": {ModTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"b.html": {ModTime: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		"c.html": {ModTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	index, err := GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedIndex := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap>
+		<loc>http://localhost:8080/sitemap-1.xml</loc>
+		<lastmod>2024-02-01T00:00:00Z</lastmod>
+	</sitemap>
+	<sitemap>
+		<loc>http://localhost:8080/sitemap-2.xml</loc>
+		<lastmod>2024-03-01T00:00:00Z</lastmod>
+	</sitemap>
+</sitemapindex>
+`
+	if diff := cmp.Diff(expectedIndex, string(index)); diff != "" {
+		t.Error(diff)
+	}
+
+	second, err := GenerateSiteMapPart(testFs, "http://localhost:8080", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(second, []byte("<loc>http://localhost:8080/c.html</loc>")) || bytes.Contains(second, []byte("a.html")) {
+		t.Errorf("expected the second part to hold only c.html, got:\n%s", second)
+	}
+
+	if _, err := GenerateSiteMapPart(testFs, "http://localhost:8080", 3); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a part past the end, got %v", err)
+	}
+}
+
+// Not parallel: it changes a package-level limit.
+func TestGenerateSiteMapSplitsWhenAPartWouldBeTooBig(t *testing.T) {
+	original := siteMapMaxBytes
+	siteMapMaxBytes = len(siteMapUrlSetHeader) + len(siteMapUrlSetFooter) + 100
+	t.Cleanup(func() { siteMapMaxBytes = original })
+
+	testFs := fstest.MapFS{
+		"a.html": {},
+		"b.html": {},
+	}
+
+	parts, err := siteMapParts(testFs, "http://localhost:8080", DefaultMaxPartialDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+
+	for i, part := range parts {
+		if len(part.content) > siteMapMaxBytes {
+			t.Errorf("part %d is %d bytes, over the %d byte limit", i+1, len(part.content), siteMapMaxBytes)
+		}
+	}
+}
+
+func TestSiteMapListsPagesWithPartialsAsDeepAsTheServerAllows(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"deep.html":                    {Data: []byte(`<title>deep</title>{{ .AndrewPartialFileOuter.html }}`)},
+		".AndrewPartialFileOuter.html": {Data: []byte(`{{ .AndrewPartialFileInner.html }}`)},
+		".AndrewPartialFileInner.html": {Data: []byte(`inner`)},
+	}
+
+	shallow, err := generateSiteMap(testFs, "http://localhost:8080", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(shallow, []byte("deep.html")) {
+		t.Errorf("expected a page with partials nested too deeply to be left out, got:\n%s", shallow)
+	}
+
+	deep, err := generateSiteMap(testFs, "http://localhost:8080", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(deep, []byte("deep.html")) {
+		t.Errorf("expected the page to be listed when its partials are nested deeply enough, got:\n%s", deep)
+	}
+}
@@ -2,6 +2,8 @@ package andrew_test
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"testing/fstest"
 
@@ -37,3 +39,165 @@ func TestGenerateSitemap_CreatesACorrectSiteMap(t *testing.T) {
 		t.Error(cmp.Diff(expected, sitemap))
 	}
 }
+
+func TestGenerateSitemapIncludesOptionalElementsFromMetaElements(t *testing.T) {
+	t.Parallel()
+
+	expected := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url>
+		<loc>http://localhost:8080/blog/</loc>
+		<lastmod>2024-03-01T00:00:00Z</lastmod>
+		<changefreq>daily</changefreq>
+		<priority>0.8</priority>
+	</url>
+	<url>
+		<loc>http://localhost:8080/blog/post.html</loc>
+		<lastmod>2024-01-28T00:00:00Z</lastmod>
+	</url>
+</urlset>
+`)
+
+	testFs := fstest.MapFS{
+		"blog/index.html": {Data: []byte(`<meta name="andrew-publish-time" content="2024-03-01">
+<meta name="andrew-sitemap-changefreq" content="Daily">
+<meta name="andrew-sitemap-priority" content="0.8">`)},
+		// Values the protocol doesn't allow are dropped.
+		"blog/post.html": {Data: []byte(`<meta name="andrew-publish-time" content="2024-01-28">
+<meta name="andrew-sitemap-changefreq" content="fortnightly">
+<meta name="andrew-sitemap-priority" content="11">`)},
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sitemap, expected) {
+		t.Error(cmp.Diff(string(expected), string(sitemap)))
+	}
+}
+
+func TestGenerateSitemapKeepsThePriorityAsGiven(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"precise.html": {Data: []byte(`<meta name="andrew-sitemap-priority" content="0.85">`)},
+		"whole.html":   {Data: []byte(`<meta name="andrew-sitemap-priority" content="1">`)},
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"<priority>0.85</priority>", "<priority>1</priority>"} {
+		if !bytes.Contains(sitemap, []byte(expected)) {
+			t.Errorf("expected %s in %s", expected, sitemap)
+		}
+	}
+}
+
+func TestGenerateSitemapLeavesOutNoIndexPages(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"page.html":   {},
+		"hidden.html": {Data: []byte(`<meta name="robots" content="NOFOLLOW, noindex">`)},
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sitemap, []byte("hidden.html")) {
+		t.Errorf("expected hidden.html to be left out, got:\n%s", sitemap)
+	}
+
+	if !bytes.Contains(sitemap, []byte("page.html")) {
+		t.Errorf("expected page.html to be listed, got:\n%s", sitemap)
+	}
+}
+
+// TestServeDoesNotInventSiteMapPartsForASmallSite checks that sitemap-1.xml only exists once
+// a sitemap has been split.
+func TestServeDoesNotInventSiteMapPartsForASmallSite(t *testing.T) {
+	t.Parallel()
+
+	s := andrew.NewServer(fstest.MapFS{"index.html": {}}, ":0", "http://localhost:8080", andrew.RssInfo{Dir: "."})
+
+	w := httptest.NewRecorder()
+	s.Serve(w, httptest.NewRequest(http.MethodGet, "/sitemap-1.xml", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServeServesARealSiteMapPartFile(t *testing.T) {
+	t.Parallel()
+
+	s := andrew.NewServer(fstest.MapFS{
+		"index.html":    {},
+		"sitemap-1.xml": {Data: []byte("<urlset>mine</urlset>")},
+	}, ":0", "http://localhost:8080", andrew.RssInfo{Dir: "."})
+
+	w := httptest.NewRecorder()
+	s.Serve(w, httptest.NewRequest(http.MethodGet, "/sitemap-1.xml", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "<urlset>mine</urlset>" {
+		t.Errorf("expected the site's own sitemap-1.xml, received %d %q", w.Code, w.Body.String())
+	}
+}
+
+// TestGenerateSitemapOnlyListsPublishablePages checks the sitemap leaves out the same pages
+// the rss feed and tables of contents do.
+func TestGenerateSitemapOnlyListsPublishablePages(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"page.html":               {},
+		"draft.html":              {Data: []byte(`<meta name="andrew-draft" content="true">`)},
+		".AndrewPartialFile.html": {Data: []byte("<header></header>")},
+		".git/description.html":   {},
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unwanted := range []string{"draft.html", "AndrewPartialFile", ".git"} {
+		if bytes.Contains(sitemap, []byte(unwanted)) {
+			t.Errorf("expected %s to be left out of the sitemap, got:\n%s", unwanted, sitemap)
+		}
+	}
+}
+
+func TestGenerateSitemapEscapesPaths(t *testing.T) {
+	t.Parallel()
+
+	testFs := fstest.MapFS{
+		"my page.html":      {},
+		"café/index.html":   {},
+		"café/today's.html": {},
+		"fish & chips.html": {},
+	}
+
+	sitemap, err := andrew.GenerateSiteMap(testFs, "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"<loc>http://localhost:8080/my%20page.html</loc>",
+		"<loc>http://localhost:8080/caf%C3%A9/</loc>",
+		"<loc>http://localhost:8080/caf%C3%A9/today%27s.html</loc>",
+		"<loc>http://localhost:8080/fish%20&amp;%20chips.html</loc>",
+	} {
+		if !bytes.Contains(sitemap, []byte(expected)) {
+			t.Errorf("expected %s in %s", expected, sitemap)
+		}
+	}
+}
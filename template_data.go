@@ -0,0 +1,98 @@
+package andrew
+
+import (
+	"io/fs"
+	"maps"
+	"regexp"
+	"runtime/debug"
+	"time"
+)
+
+// SiteData is what every page and partial can find out about the site it's part of, as
+// {{ .Site }}. For example, {{ .Site.BaseUrl }} or {{ .Site.Year }}.
+type SiteData struct {
+	BaseUrl        string
+	RssTitle       string
+	RssDescription string
+	Version        string // The version of Andrew serving the site.
+	Year           int    // The current year, for copyright notices.
+}
+
+// pageDataFinder recognises a page that uses its template data, or lists or reads other
+// files, so it needs executing as a template even though it has no table of contents. Pages
+// that only have other {{ }} in them, like the templates of a javascript framework, are left
+// alone.
+var pageDataFinder = regexp.MustCompile(`{{[^}]*(?:\.(?:Page|Site|Meta)\b|\b(?:pagesIn|readFile)\s)`)
+
+// siteData is the {{ .Site }} of every page this Server renders.
+func (s *Server) siteData() SiteData {
+	return SiteData{
+		BaseUrl:        s.BaseUrl,
+		RssTitle:       s.RssInfo.Title,
+		RssDescription: s.RssInfo.Description,
+		Version:        andrewVersion(),
+		Year:           time.Now().Year(),
+	}
+}
+
+// andrewVersion is the module version Andrew was built from, as go install records it, or
+// "(devel)" for a build from a checkout.
+func andrewVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
+
+// templateData is what pages and partials are executed with: {{ .Page }} is the Page being
+// rendered, {{ .Site }} is the SiteData, and {{ .Meta }} is a shortcut for {{ .Page.Meta }},
+// so {{ .Meta.description }} is the page's <meta name="description">.
+func templateData(page Page, site SiteData) map[string]any {
+	meta := page.Meta
+	if meta == nil {
+		meta = map[string]string{}
+	}
+
+	return map[string]any{"Page": page, "Site": site, "Meta": meta}
+}
+
+// partialTemplateData is what a page's partials see of it. Partials are rendered before the
+// rest of the page is read, so this is what the page says about itself outside its partials.
+func partialTemplateData(siteFiles fs.FS, pagePath string, pageContent []byte, markdownPage *markdownPage, site SiteData) (map[string]any, error) {
+	title, err := getTitle(pagePath, pageContent)
+	if err != nil {
+		return nil, err
+	}
+
+	publishTime, err := getPublishTime(siteFiles, sourcePath(pagePath, markdownPage), pageContent)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := GetMetaElements(pageContent)
+	if err != nil {
+		return nil, err
+	}
+
+	page := withFrontMatter(Page{Title: title, UrlPath: pagePath, PublishTime: publishTime, Meta: meta}, markdownPage)
+
+	return templateData(page, site), nil
+}
+
+// withData returns a copy of data with extra on top of it. A page's template gets its table
+// of contents this way, and a partial the key/value pairs from its directive, which win over
+// anything of the page's with the same name.
+func withData(data map[string]any, extra map[string]string) map[string]any {
+	merged := maps.Clone(data)
+	if merged == nil {
+		merged = map[string]any{}
+	}
+
+	for key, value := range extra {
+		merged[key] = value
+	}
+
+	return merged
+}
@@ -0,0 +1,91 @@
+package andrew_test
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+func TestPageCanUseItsTemplateData(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{
+		BaseUrl: "https://example.com",
+		RssInfo: andrew.RssInfo{Title: "Example"},
+		SiteFiles: fstest.MapFS{
+			"blog/post.html": {Data: []byte(`<title>A post</title>
+<meta name="description" content="All about posts">
+<meta name="andrew-publish-time" content="2025-03-30">
+<h1>{{ .Page.Title }}</h1>
+<p>{{ .Meta.description }}, {{ .Page.PublishTime.Format "2006-01-02" }}</p>
+<link rel="canonical" href="{{ .Site.BaseUrl }}/{{ .Page.UrlPath }}">
+<footer>{{ .Site.RssTitle }} {{ .Site.Year }}</footer>`)},
+		},
+	}
+
+	page, err := server.NewPage("blog/post.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`<title>A post</title>
+<meta name="description" content="All about posts">
+<meta name="andrew-publish-time" content="2025-03-30">
+<h1>A post</h1>
+<p>All about posts, 2025-03-30</p>
+<link rel="canonical" href="https://example.com/blog/post.html">
+<footer>Example %d</footer>`, time.Now().Year())
+
+	if page.Content != expected {
+		t.Errorf("expected %q, received %q", expected, page.Content)
+	}
+}
+
+func TestPartialCanUseThePagesTemplateData(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{
+		BaseUrl: "https://example.com",
+		SiteFiles: fstest.MapFS{
+			".AndrewPartialFileHeader.html": {Data: []byte(`<head><title>{{ .Page.Title }} | {{ .section }}</title><meta name="description" content="{{ .Meta.description }}"><link rel="canonical" href="{{ .Site.BaseUrl }}/{{ .Page.UrlPath }}"></head>`)},
+			"index.html":                    {Data: []byte(`<meta name="description" content="The front page">{{ .AndrewPartialFileHeader.html section=home }}{{ .AndrewTableOfContents }}`)},
+		},
+	}
+
+	page, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<meta name="description" content="The front page"><head><title>index.html | home</title><meta name="description" content="The front page"><link rel="canonical" href="https://example.com/index.html"></head><div class="AndrewTableOfContents">
+<ul>
+</ul>
+</div>
+`
+
+	if page.Content != expected {
+		t.Errorf("expected %q, received %q", expected, page.Content)
+	}
+}
+
+func TestPageWithoutAndrewTemplateDataIsLeftAlone(t *testing.T) {
+	t.Parallel()
+
+	content := `<div id="app">{{ message }} {{ .Pages }}</div>`
+
+	server := andrew.Server{SiteFiles: fstest.MapFS{
+		"app.html": {Data: []byte(content)},
+	}}
+
+	page, err := server.NewPage("app.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Content != content {
+		t.Errorf("expected %q, received %q", content, page.Content)
+	}
+}
@@ -0,0 +1,209 @@
+package andrew
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs is the function library every page, partial and layout template can use.
+// siteFiles, site and pagePath are what the functions that need to know where they are use:
+// readFile and pagesIn find paths relative to the page, or to the site's root when they start
+// with a /, and absURL builds on the site's BaseUrl. pagesIn reads pages whose partials are
+// nested up to maxPartialDepth deep.
+//
+// Andrew's templates are text/template, which doesn't escape values by where they're put
+// the way html/template does; they go into the page as they are unless a template escapes
+// them with escapeHTML, or text/template's own html, js and urlquery. safeHTML returns its
+// argument unchanged. It's kept so templates written for Hugo, which marks trusted html with
+// it, run here without edits.
+func templateFuncs(siteFiles fs.FS, site SiteData, pagePath string, maxPartialDepth int) template.FuncMap {
+	return template.FuncMap{
+		// dates
+		"now":  time.Now,
+		"date": formatDate,
+
+		// links
+		"absURL": func(link string) string { return absURL(site.BaseUrl, link) },
+		"relURL": relURL,
+
+		// strings
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"replace":    func(s, old, new string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(s, substr string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(s, prefix string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(s, suffix string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(s, sep string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"escapeHTML": html.EscapeString,
+
+		// html
+		"markdownify": markdownify,
+		"safeHTML":    func(s string) string { return s },
+
+		// files and pages
+		"readFile": func(name string) (string, error) {
+			if siteFiles == nil {
+				return "", fs.ErrNotExist
+			}
+			content, err := fs.ReadFile(siteFiles, sitePath(pagePath, name))
+			return string(content), err
+		},
+		"pagesIn": func(dir string) ([]Page, error) {
+			if siteFiles == nil {
+				return nil, fs.ErrNotExist
+			}
+			pages, err := pagesInDir(siteFiles, sitePath(pagePath, dir), maxPartialDepth)
+			return SortPagesByDate(pages), err
+		},
+
+		// collections of pages
+		"where":  where,
+		"sortBy": sortBy,
+		"first":  first,
+	}
+}
+
+// listingTemplateFuncs is templateFuncs for the partials of pages that are only being read
+// for a listing. pagesIn lists nothing there: a partial on every page that lists pages
+// would otherwise have each page it lists list pages too, forever.
+func listingTemplateFuncs(siteFiles fs.FS, pagePath string) template.FuncMap {
+	funcs := templateFuncs(siteFiles, SiteData{}, pagePath, 0)
+	funcs["pagesIn"] = func(string) ([]Page, error) { return nil, nil }
+
+	return funcs
+}
+
+// formatDate is {{ date "2006-01-02" .Page.PublishTime }}, a time in Go's layout notation.
+func formatDate(layout string, t time.Time) string {
+	return t.Format(layout)
+}
+
+// absURL is link as a full URL on the site. Links that already have a scheme are left alone.
+func absURL(baseUrl string, link string) string {
+	if strings.Contains(link, "://") {
+		return link
+	}
+
+	return strings.TrimSuffix(baseUrl, "/") + relURL(link)
+}
+
+// relURL is link relative to the site's root, which is what a page's UrlPath needs to link to
+// it from anywhere on the site.
+func relURL(link string) string {
+	if strings.Contains(link, "://") {
+		return link
+	}
+
+	return "/" + strings.TrimPrefix(link, "/")
+}
+
+// markdownify renders Markdown, such as a meta element's content, into html.
+func markdownify(source string) (string, error) {
+	var rendered bytes.Buffer
+	if err := markdown.Convert([]byte(source), &rendered); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(rendered.String()), nil
+}
+
+// sitePath finds name relative to the page at pagePath, or relative to the site's root when
+// it starts with a /.
+func sitePath(pagePath string, name string) string {
+	if strings.HasPrefix(name, "/") {
+		return path.Clean("." + name)
+	}
+
+	return path.Join(path.Dir(pagePath), name)
+}
+
+// where is {{ where (pagesIn "blog") "Meta.author" "me" }}: the pages whose key has the
+// value. key is Title or UrlPath, Meta.name for the meta element called name, or Tags, which
+// matches a page that has the value among its tags.
+func where(pages []Page, key string, value string) ([]Page, error) {
+	matching := []Page{}
+
+	for _, page := range pages {
+		if key == "Tags" {
+			if slices.Contains(page.Tags, value) {
+				matching = append(matching, page)
+			}
+			continue
+		}
+
+		field, err := pageField(page, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if field == value {
+			matching = append(matching, page)
+		}
+	}
+
+	return matching, nil
+}
+
+// sortBy is {{ sortBy (pagesIn "blog") "Title" }}, the pages in order of one of their fields:
+// Title, UrlPath, PublishTime or Meta.name. They're in ascending order, unless the last
+// argument is "desc".
+func sortBy(pages []Page, key string, order ...string) ([]Page, error) {
+	descending := len(order) > 0 && order[0] == "desc"
+
+	if _, err := pageField(Page{}, key); err != nil {
+		return nil, err
+	}
+
+	sorted := slices.Clone(pages)
+	slices.SortStableFunc(sorted, func(a, b Page) int {
+		var c int
+		if key == "PublishTime" {
+			c = a.PublishTime.Compare(b.PublishTime)
+		} else {
+			aField, _ := pageField(a, key)
+			bField, _ := pageField(b, key)
+			c = strings.Compare(aField, bField)
+		}
+
+		if descending {
+			return -c
+		}
+		return c
+	})
+
+	return sorted, nil
+}
+
+// first is {{ first 5 (pagesIn "blog") }}, the first n pages, or all of them if there are fewer.
+func first(n int, pages []Page) []Page {
+	if n < 0 {
+		n = 0
+	}
+
+	return pages[:min(n, len(pages))]
+}
+
+// pageField is the value of one of a page's fields, for where and sortBy to compare.
+func pageField(page Page, key string) (string, error) {
+	switch {
+	case key == "Title":
+		return page.Title, nil
+	case key == "UrlPath":
+		return page.UrlPath, nil
+	case key == "PublishTime":
+		return page.PublishTime.Format(time.RFC3339), nil
+	case strings.HasPrefix(key, "Meta."):
+		return page.Meta[strings.TrimPrefix(key, "Meta.")], nil
+	}
+
+	return "", fmt.Errorf("pages can't be compared by %q", key)
+}
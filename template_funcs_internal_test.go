@@ -0,0 +1,118 @@
+package andrew
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testPages() []Page {
+	return []Page{
+		{Title: "b", UrlPath: "blog/b.html", PublishTime: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Meta: map[string]string{"author": "me"}, Tags: []string{"go"}},
+		{Title: "a", UrlPath: "blog/a.html", PublishTime: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), Meta: map[string]string{"author": "you"}},
+		{Title: "c", UrlPath: "blog/c.html", PublishTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Meta: map[string]string{"author": "me"}, Tags: []string{"go", "web"}},
+	}
+}
+
+func titles(pages []Page) []string {
+	t := []string{}
+	for _, page := range pages {
+		t = append(t, page.Title)
+	}
+	return t
+}
+
+func TestWhere(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		key      string
+		value    string
+		expected []string
+	}{
+		{key: "Meta.author", value: "me", expected: []string{"b", "c"}},
+		{key: "Tags", value: "web", expected: []string{"c"}},
+		{key: "Title", value: "a", expected: []string{"a"}},
+		{key: "UrlPath", value: "nowhere.html", expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		matching, err := where(testPages(), tc.key, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tc.expected, titles(matching)); diff != "" {
+			t.Errorf("where %s %s: %s", tc.key, tc.value, diff)
+		}
+	}
+
+	if _, err := where(testPages(), "Colour", "red"); err == nil {
+		t.Error("expected an error for a key pages don't have")
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		key      string
+		order    []string
+		expected []string
+	}{
+		{key: "Title", expected: []string{"a", "b", "c"}},
+		{key: "Title", order: []string{"desc"}, expected: []string{"c", "b", "a"}},
+		{key: "PublishTime", expected: []string{"c", "b", "a"}},
+		{key: "Meta.author", expected: []string{"b", "c", "a"}},
+	}
+
+	for _, tc := range testCases {
+		sorted, err := sortBy(testPages(), tc.key, tc.order...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tc.expected, titles(sorted)); diff != "" {
+			t.Errorf("sortBy %s %v: %s", tc.key, tc.order, diff)
+		}
+	}
+
+	if _, err := sortBy(testPages(), "Colour"); err == nil {
+		t.Error("expected an error for a key pages don't have")
+	}
+}
+
+func TestFirst(t *testing.T) {
+	t.Parallel()
+
+	for n, expected := range map[int][]string{-1: {}, 0: {}, 2: {"b", "a"}, 10: {"b", "a", "c"}} {
+		if diff := cmp.Diff(expected, titles(first(n, testPages()))); diff != "" {
+			t.Errorf("first %d: %s", n, diff)
+		}
+	}
+}
+
+func TestAbsAndRelURL(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		link        string
+		expectedAbs string
+		expectedRel string
+	}{
+		{link: "blog/post.html", expectedAbs: "https://example.com/blog/post.html", expectedRel: "/blog/post.html"},
+		{link: "/blog/", expectedAbs: "https://example.com/blog/", expectedRel: "/blog/"},
+		{link: "https://elsewhere.com/", expectedAbs: "https://elsewhere.com/", expectedRel: "https://elsewhere.com/"},
+	}
+
+	for _, tc := range testCases {
+		if received := absURL("https://example.com/", tc.link); received != tc.expectedAbs {
+			t.Errorf("absURL %q: expected %q, received %q", tc.link, tc.expectedAbs, received)
+		}
+
+		if received := relURL(tc.link); received != tc.expectedRel {
+			t.Errorf("relURL %q: expected %q, received %q", tc.link, tc.expectedRel, received)
+		}
+	}
+}
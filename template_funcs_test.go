@@ -0,0 +1,87 @@
+package andrew_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/playtechnique/andrew"
+)
+
+func TestPageCanBuildACustomListing(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{
+		BaseUrl: "https://example.com",
+		SiteFiles: fstest.MapFS{
+			"index.html": {Data: []byte(`<ul>
+{{- range first 2 (where (pagesIn "blog") "Meta.author" "me") }}
+<li><a href="{{ absURL .UrlPath }}">{{ upper .Title }}</a> {{ date "2 Jan 2006" .PublishTime }}</li>
+{{- end }}
+</ul>`)},
+			"blog/one.html":   {Data: []byte(`<title>one</title><meta name="author" content="me"><meta name="andrew-publish-time" content="2025-01-01">`)},
+			"blog/two.html":   {Data: []byte(`<title>two</title><meta name="author" content="me"><meta name="andrew-publish-time" content="2025-01-03">`)},
+			"blog/three.html": {Data: []byte(`<title>three</title><meta name="author" content="me"><meta name="andrew-publish-time" content="2025-01-02">`)},
+			"blog/four.html":  {Data: []byte(`<title>four</title><meta name="author" content="you"><meta name="andrew-publish-time" content="2025-01-04">`)},
+		},
+	}
+
+	page, err := server.NewPage("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<ul>
+<li><a href="https://example.com/blog/two.html">TWO</a> 3 Jan 2025</li>
+<li><a href="https://example.com/blog/three.html">THREE</a> 2 Jan 2025</li>
+</ul>`
+
+	if page.Content != expected {
+		t.Errorf("expected %q, received %q", expected, page.Content)
+	}
+}
+
+func TestPartialCanReadFilesAndRenderMarkdown(t *testing.T) {
+	t.Parallel()
+
+	server := andrew.Server{
+		SiteFiles: fstest.MapFS{
+			".AndrewPartialFileBio.html": {Data: []byte(`{{ markdownify (readFile "/bio.md") }}{{ safeHTML "<hr>" }}{{ escapeHTML .Meta.tagline }}`)},
+			"bio.md":                     {Data: []byte("I write *things*.")},
+			"about/index.html":           {Data: []byte(`<meta name="tagline" content="Tom & Jerry">{{ .AndrewPartialFileBio.html }}`)},
+		},
+	}
+
+	page, err := server.NewPage("about/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<meta name="tagline" content="Tom & Jerry"><p>I write <em>things</em>.</p><hr>Tom &amp; Jerry`
+
+	if page.Content != expected {
+		t.Errorf("expected %q, received %q", expected, page.Content)
+	}
+}
+
+func TestPagesInAPartialOnEveryPageDoesNotRecurse(t *testing.T) {
+	t.Parallel()
+
+	sidebar := []byte(`{{ range pagesIn "/" }}[{{ .Title }}]{{ end }}`)
+
+	server := andrew.Server{
+		SiteFiles: fstest.MapFS{
+			".AndrewPartialFileSidebar.html": {Data: sidebar},
+			"a.html":                         {Data: []byte(`<title>a</title>{{ .AndrewPartialFileSidebar.html }}`)},
+			"b.html":                         {Data: []byte(`<title>b</title>{{ .AndrewPartialFileSidebar.html }}`)},
+		},
+	}
+
+	page, err := server.NewPage("a.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.Content != `<title>a</title>[a][b]` && page.Content != `<title>a</title>[b][a]` {
+		t.Errorf("expected the sidebar to list both pages, received %q", page.Content)
+	}
+}
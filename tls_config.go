@@ -0,0 +1,205 @@
+package andrew
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultTLSMinVersion is TLS 1.2: what's left after the versions browsers have dropped.
+const DefaultTLSMinVersion = tls.VersionTLS12
+
+// tlsVersions are the versions --tls-min-version can be set to.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the key exchanges --tls-curves can choose from, by the names they're
+// usually given.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
+// ParseTLSVersion reads a TLS version as it's written on the command line, like 1.3.
+func ParseTLSVersion(version string) (uint16, error) {
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("--tls-min-version must be 1.2 or 1.3, not %q", version)
+}
+
+// ParseCipherSuites reads a comma separated list of cipher suites, by their IANA names like
+// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, in order of preference. Only the suites Go
+// considers secure are allowed, and only TLS 1.2's: TLS 1.3's can't be chosen.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	suites := []uint16{}
+
+	for _, name := range splitList(names) {
+		i := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return suite.Name == name })
+		if i == -1 {
+			return nil, fmt.Errorf("--tls-ciphers: %q isn't a cipher suite Andrew can use; see Go's tls.CipherSuites", name)
+		}
+
+		suite := tls.CipherSuites()[i]
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("--tls-ciphers: %s is a TLS 1.3 cipher suite, and those can't be chosen", name)
+		}
+
+		suites = append(suites, suite.ID)
+	}
+
+	if len(suites) == 0 {
+		return nil, errors.New("--tls-ciphers needs at least one cipher suite")
+	}
+
+	return suites, nil
+}
+
+// ParseCurves reads a comma separated list of key exchanges, like X25519,P-256, in order of
+// preference.
+func ParseCurves(names string) ([]tls.CurveID, error) {
+	curves := []tls.CurveID{}
+
+	for _, name := range splitList(names) {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("--tls-curves must be from X25519, P-256, P-384 and P-521, not %q", name)
+		}
+
+		curves = append(curves, curve)
+	}
+
+	if len(curves) == 0 {
+		return nil, errors.New("--tls-curves needs at least one curve")
+	}
+
+	return curves, nil
+}
+
+// configureTLS applies the server's TLSInfo to the config it serves https with. Paths that
+// need a client certificate are checked in Serve, because the handshake doesn't know what
+// path is going to be asked for: so a client certificate is asked for, and checked against
+// ClientCAPath, but not required.
+func (a *Server) configureTLS(config *tls.Config) error {
+	config.MinVersion = a.TLSInfo.MinVersion
+	if config.MinVersion == 0 {
+		config.MinVersion = DefaultTLSMinVersion
+	}
+	config.CipherSuites = a.TLSInfo.CipherSuites
+	config.CurvePreferences = a.TLSInfo.Curves
+
+	if a.TLSInfo.ClientCAPath == "" {
+		return nil
+	}
+
+	pem, err := os.ReadFile(a.TLSInfo.ClientCAPath)
+	if err != nil {
+		return fmt.Errorf("reading --client-ca: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return errors.New("--client-ca has no PEM certificates in it: " + a.TLSInfo.ClientCAPath)
+	}
+
+	config.ClientCAs = clientCAs
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return nil
+}
+
+// needsClientCert reports whether the file at pagePath, relative to the root of the site,
+// is in one of the areas only clients with a certificate from ClientCAPath can read.
+func (a *Server) needsClientCert(pagePath string) bool {
+	for _, prefix := range a.TLSInfo.ClientCertPaths {
+		prefix = strings.Trim(path.Clean("/"+prefix), "/")
+		if prefix == "" || pagePath == prefix || strings.HasPrefix(pagePath, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIdentity is the subject of the client certificate the request was made with, if
+// there was one and it was verified against ClientCAPath.
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return r.TLS.VerifiedChains[0][0].Subject.String()
+}
+
+// listedFiles is the site as the listings on the page at pagePath see it. A page that isn't
+// in an area that needs a client certificate can't list, or read, the pages in one, so the
+// titles of a private area don't turn up in a public table of contents, the sitemap or the
+// rss feed.
+func (a *Server) listedFiles(pagePath string) fs.FS {
+	if len(a.TLSInfo.ClientCertPaths) == 0 || (pagePath != "" && a.needsClientCert(pagePath)) {
+		return a.SiteFiles
+	}
+
+	return &publicFS{fsys: a.SiteFiles, server: a}
+}
+
+// publicFS is a site without its areas that need a client certificate: they're reported
+// as not existing, and left out of directory listings.
+type publicFS struct {
+	fsys   fs.FS
+	server *Server
+}
+
+func (p *publicFS) Open(name string) (fs.File, error) {
+	if name != "." && p.server.needsClientCert(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	file, err := p.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir, ok := file.(fs.ReadDirFile); ok {
+		return &publicDir{ReadDirFile: dir, fs: p, name: name}, nil
+	}
+
+	return file, nil
+}
+
+// publicDir is a directory in a publicFS.
+type publicDir struct {
+	fs.ReadDirFile
+	fs   *publicFS
+	name string
+}
+
+func (d *publicDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	for {
+		entries, err := d.ReadDirFile.ReadDir(n)
+
+		kept := []fs.DirEntry{}
+		for _, entry := range entries {
+			if !d.fs.server.needsClientCert(path.Join(d.name, entry.Name())) {
+				kept = append(kept, entry)
+			}
+		}
+
+		// A caller asking for n entries is owed at least one, or an error.
+		if n <= 0 || len(kept) > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package andrew
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNeedsClientCertMatchesWholePathSegments(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{TLSInfo: TLSInfo{ClientCertPaths: []string{"/drafts/", "private"}}}
+
+	for pagePath, expected := range map[string]bool{
+		"drafts":               true,
+		"drafts/index.html":    true,
+		"drafts/2024/post.png": true,
+		"private/notes.html":   true,
+		"drafts.html":          false,
+		"draftsman/index.html": false,
+		"blog/drafts/a.html":   false,
+		"index.html":           false,
+	} {
+		if received := s.needsClientCert(pagePath); received != expected {
+			t.Errorf("%s: expected %t, received %t", pagePath, expected, received)
+		}
+	}
+}
+
+func TestClientIdentityIsTheVerifiedSubject(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	if identity := clientIdentity(r); identity != "" {
+		t.Errorf("expected no identity without a certificate, received %q", identity)
+	}
+
+	// A certificate the client sent, but that wasn't verified, identifies nobody.
+	editor := &x509.Certificate{Subject: pkix.Name{CommonName: "editor", Organization: []string{"playtechnique"}}}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{editor}}
+	if identity := clientIdentity(r); identity != "" {
+		t.Errorf("expected no identity from an unverified certificate, received %q", identity)
+	}
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{editor}}
+	if identity := clientIdentity(r); identity != "CN=editor,O=playtechnique" {
+		t.Errorf("expected CN=editor,O=playtechnique, received %q", identity)
+	}
+}
+
+func TestConfigureTLSAsksForClientCertificates(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{TLSInfo: TLSInfo{ClientCAPath: "testdata/acme-ca.pem"}}
+	config := &tls.Config{}
+
+	if err := s.configureTLS(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 at least by default, received %x", config.MinVersion)
+	}
+
+	// Asked for, but not required: most of the site can be read without one.
+	if config.ClientAuth != tls.VerifyClientCertIfGiven || config.ClientCAs == nil {
+		t.Errorf("expected client certificates to be checked if given, received %v", config.ClientAuth)
+	}
+
+	s.TLSInfo.ClientCAPath = "tls_config.go"
+	if err := s.configureTLS(&tls.Config{}); err == nil {
+		t.Error("expected an error for a --client-ca without certificates")
+	}
+}
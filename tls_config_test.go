@@ -0,0 +1,217 @@
+package andrew_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+// newTestClientCA makes a CA, writes it to a PEM file and returns the file's path, along
+// with a client certificate it signed for commonName.
+func newTestClientCA(t *testing.T, commonName string) (string, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "andrew test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "client-ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return caPath, tls.Certificate{Certificate: [][]byte{clientDer}, PrivateKey: clientKey}
+}
+
+func httpsClient(certificates ...tls.Certificate) *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       certificates,
+	}}}
+}
+
+func httpsGet(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func privateAreaSite() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":        {Data: []byte(`<title>home</title>{{ .AndrewTableOfContentsWithDirectories }}`)},
+		"blog/post.html":    {Data: []byte(`<title>public post</title>`)},
+		"drafts/index.html": {Data: []byte(`<title>drafts</title>{{ .AndrewTableOfContents }}`)},
+		"drafts/post.html":  {Data: []byte(`<title>secret post</title>`)},
+		"drafts/403.html":   {Data: []byte(`<title>secret error page</title>`)},
+	}
+}
+
+func TestClientCertPathsNeedAClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	caPath, clientCert := newTestClientCA(t, "editor")
+
+	s := newTestHttpsServer(t, privateAreaSite(), func(s *andrew.Server) {
+		s.TLSInfo = andrew.TLSInfo{ClientCAPath: caPath, ClientCertPaths: []string{"/drafts/"}}
+	})
+
+	anonymous, editor := httpsClient(), httpsClient(clientCert)
+
+	for _, private := range []string{"/drafts/", "/drafts/post.html", "/drafts"} {
+		status, body := httpsGet(t, anonymous, s.BaseUrl+private)
+		if status != http.StatusForbidden {
+			t.Errorf("%s: expected status %d without a client certificate, received %d", private, http.StatusForbidden, status)
+		}
+		if strings.Contains(body, "secret") {
+			t.Errorf("%s: expected nothing from the private area without a client certificate, received %q", private, body)
+		}
+	}
+
+	status, body := httpsGet(t, editor, s.BaseUrl+"/drafts/post.html")
+	if status != http.StatusOK || !strings.Contains(body, "secret post") {
+		t.Errorf("expected the secret post with a client certificate, received %d %q", status, body)
+	}
+
+	// The private area can list itself, to whoever can read it.
+	status, body = httpsGet(t, editor, s.BaseUrl+"/drafts/")
+	if status != http.StatusOK || !strings.Contains(body, "secret post") {
+		t.Errorf("expected the drafts listing to list the secret post, received %d %q", status, body)
+	}
+
+	status, body = httpsGet(t, anonymous, s.BaseUrl+"/blog/post.html")
+	if status != http.StatusOK {
+		t.Errorf("expected the public post without a client certificate, received %d", status)
+	}
+
+	for _, listing := range []string{"/", "/sitemap.xml", "/rss.xml"} {
+		_, body := httpsGet(t, editor, s.BaseUrl+listing)
+		if !strings.Contains(body, "blog/post.html") {
+			t.Errorf("%s: expected the public post to be listed, received %q", listing, body)
+		}
+		if strings.Contains(body, "drafts") || strings.Contains(body, "secret") {
+			t.Errorf("%s: expected the private area not to be listed, received %q", listing, body)
+		}
+	}
+}
+
+func TestTLSMinVersionRefusesOlderClients(t *testing.T) {
+	t.Parallel()
+
+	s := newTestHttpsServer(t, fstest.MapFS{"index.html": {Data: []byte(`<title>home</title>`)}}, func(s *andrew.Server) {
+		s.TLSInfo = andrew.TLSInfo{MinVersion: tls.VersionTLS13}
+	})
+
+	tls12 := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}}}
+	if resp, err := tls12.Get(s.BaseUrl + "/"); err == nil {
+		resp.Body.Close()
+		t.Error("expected a TLS 1.2 client to be refused")
+	}
+
+	if status, _ := httpsGet(t, httpsClient(), s.BaseUrl+"/"); status != http.StatusOK {
+		t.Errorf("expected a TLS 1.3 client to be served, received %d", status)
+	}
+}
+
+func TestPrivatePagesAliasesNeedAClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	caPath, clientCert := newTestClientCA(t, "editor")
+
+	site := privateAreaSite()
+	site["drafts/post.html"] = &fstest.MapFile{Data: []byte(`<meta name="andrew-redirect-from" content="/coming-soon.html"><title>secret post</title>`)}
+
+	s := newTestHttpsServer(t, site, func(s *andrew.Server) {
+		s.TLSInfo = andrew.TLSInfo{ClientCAPath: caPath, ClientCertPaths: []string{"/drafts/"}}
+	})
+
+	anonymous, editor := httpsClient(), httpsClient(clientCert)
+	for _, client := range []*http.Client{anonymous, editor} {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+
+	resp, err := anonymous.Get(s.BaseUrl + "/coming-soon.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Location") != "" {
+		t.Errorf("expected a private page's alias to be a 404 without a client certificate, received %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, err = editor.Get(s.BaseUrl + "/coming-soon.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/drafts/post.html" {
+		t.Errorf("expected a private page's alias to redirect with a client certificate, received %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
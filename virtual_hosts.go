@@ -0,0 +1,431 @@
+package andrew
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// SitesConfig is the file --sites reads, to serve several sites from one Andrew. It's JSON:
+//
+//	{
+//	  "sites": [
+//	    {"hosts": ["www.example.com", "example.com"], "contentRoot": "example", "baseUrl": "https://www.example.com", "default": true},
+//	    {"hosts": ["blog.example.org"], "contentRoot": "/srv/blog", "rssTitle": "Blog", "cert": "blog.crt", "privateKey": "blog.key"}
+//	  ]
+//	}
+type SitesConfig struct {
+	Sites []SiteConfig `json:"sites"`
+}
+
+// SiteConfig is one site in a SitesConfig. Relative paths in it are relative to the
+// directory the config file is in.
+type SiteConfig struct {
+	Hosts          []string `json:"hosts"`          // The hostnames the site answers to. The first one names the site in metrics.
+	ContentRoot    string   `json:"contentRoot"`    // The site's files.
+	BaseUrl        string   `json:"baseUrl"`        // Defaults to https:// or http:// and the first host, depending on whether Andrew serves https.
+	RssTitle       string   `json:"rssTitle"`       // Defaults to --rsstitle.
+	RssDescription string   `json:"rssDescription"` // Defaults to --rssdescription.
+	RssDir         string   `json:"rssDir"`         // Defaults to the whole site.
+	CertPath       string   `json:"cert"`           // The site's own certificate, chosen by SNI. Defaults to --cert, or --acme.
+	PrivateKeyPath string   `json:"privateKey"`     // The key for CertPath.
+	Default        bool     `json:"default"`        // Answer requests for hosts no site answers to. Defaults to the first site.
+}
+
+// LoadSitesConfig reads and checks the sites config at configPath.
+func LoadSitesConfig(configPath string) (SitesConfig, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return SitesConfig{}, fmt.Errorf("reading --sites: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	config := SitesConfig{}
+	if err := decoder.Decode(&config); err != nil {
+		return SitesConfig{}, fmt.Errorf("reading --sites %s: %w", configPath, err)
+	}
+
+	configDir := filepath.Dir(configPath)
+	seen := map[string]bool{}
+	defaults := 0
+
+	if len(config.Sites) == 0 {
+		return SitesConfig{}, fmt.Errorf("--sites %s has no sites in it", configPath)
+	}
+
+	for i := range config.Sites {
+		site := &config.Sites[i]
+
+		if len(site.Hosts) == 0 {
+			return SitesConfig{}, fmt.Errorf("--sites %s: site %d has no hosts", configPath, i+1)
+		}
+
+		for j, host := range site.Hosts {
+			host = normalizeHost(host)
+			if host == "" || seen[host] {
+				return SitesConfig{}, fmt.Errorf("--sites %s: %q is empty, or answered by more than one site", configPath, site.Hosts[j])
+			}
+			seen[host], site.Hosts[j] = true, host
+		}
+
+		if site.ContentRoot == "" {
+			return SitesConfig{}, fmt.Errorf("--sites %s: %s has no contentRoot", configPath, site.Hosts[0])
+		}
+
+		if (site.CertPath == "") != (site.PrivateKeyPath == "") {
+			return SitesConfig{}, fmt.Errorf("--sites %s: %s needs both cert and privateKey, or neither", configPath, site.Hosts[0])
+		}
+
+		site.ContentRoot = relativeTo(configDir, site.ContentRoot)
+		site.CertPath = relativeTo(configDir, site.CertPath)
+		site.PrivateKeyPath = relativeTo(configDir, site.PrivateKeyPath)
+
+		if site.Default {
+			defaults++
+		}
+	}
+
+	if defaults > 1 {
+		return SitesConfig{}, fmt.Errorf("--sites %s: only one site can be the default", configPath)
+	}
+
+	return config, nil
+}
+
+// relativeTo is filePath, if it's relative, as a path relative to dir.
+func relativeTo(dir string, filePath string) string {
+	if filePath == "" || filepath.IsAbs(filePath) {
+		return filePath
+	}
+
+	return filepath.Join(dir, filePath)
+}
+
+// normalizeHost is a hostname as it's compared: in lower case, without a port or the dot
+// that ends a fully qualified name.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// VirtualHosts serves several sites from one address, each with its own Server, and so its
+// own pages, sitemap, feed, redirects and headers. A request goes to the site whose hosts
+// include the request's Host, and a TLS handshake gets the certificate for its SNI server
+// name. Requests for hosts none of the sites answer to go to the default site.
+type VirtualHosts struct {
+	Address    string
+	HTTPServer *http.Server
+
+	sites       []*virtualHost
+	hosts       map[string]*virtualHost
+	defaultSite *virtualHost
+
+	cert           *certReloader     // --cert, for the sites without their own.
+	acmeManager    *autocert.Manager // --acme, for the sites without their own certificate.
+	redirectServer *http.Server
+	stopReloads    []func()
+}
+
+// virtualHost is one of the sites a VirtualHosts serves.
+type virtualHost struct {
+	name   string // The site's first host, which is its label in metrics.
+	config SiteConfig
+	server *Server
+	cert   *certReloader
+}
+
+// NewVirtualHosts builds a Server for each site in config, set up with opts as though it
+// had been started on its own, to be served together at address.
+func NewVirtualHosts(config SitesConfig, address string, opts *Options) (*VirtualHosts, error) {
+	v := &VirtualHosts{Address: address, hosts: map[string]*virtualHost{}}
+
+	useTLS, err := sitesServeTLS(config.Sites, opts.CertInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http://"
+	if useTLS {
+		scheme = "https://"
+	}
+
+	for _, site := range config.Sites {
+		baseUrl := site.BaseUrl
+		if baseUrl == "" {
+			baseUrl = scheme + site.Hosts[0]
+		}
+
+		rssInfo := RssInfo{Title: site.RssTitle, Description: site.RssDescription, Dir: site.RssDir}
+		if rssInfo.Title == "" {
+			rssInfo.Title = opts.RssInfo.Title
+		}
+		if rssInfo.Description == "" {
+			rssInfo.Description = opts.RssInfo.Description
+		}
+		if rssInfo.Dir == "" {
+			rssInfo.Dir = DefaultRssRoot
+		}
+
+		server, err := newServerFromOptions(site.ContentRoot, address, baseUrl, rssInfo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", site.Hosts[0], err)
+		}
+
+		server.siteName = site.Hosts[0]
+
+		vhost := &virtualHost{name: site.Hosts[0], config: site, server: server}
+		v.sites = append(v.sites, vhost)
+
+		for _, host := range site.Hosts {
+			v.hosts[normalizeHost(host)] = vhost
+		}
+
+		if site.Default {
+			v.defaultSite = vhost
+		}
+	}
+
+	if len(v.sites) == 0 {
+		return nil, errors.New("no sites to serve")
+	}
+
+	if v.defaultSite == nil {
+		v.defaultSite = v.sites[0]
+	}
+
+	v.HTTPServer = &http.Server{Addr: address, Handler: v}
+
+	return v, nil
+}
+
+// Site is the Server that answers requests for host.
+func (v *VirtualHosts) Site(host string) *Server {
+	return v.siteFor(host).server
+}
+
+func (v *VirtualHosts) siteFor(host string) *virtualHost {
+	if site, ok := v.hosts[normalizeHost(host)]; ok {
+		return site
+	}
+
+	return v.defaultSite
+}
+
+// ServeHTTP hands the request to the site for its Host, and counts it under the site's name.
+func (v *VirtualHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site := v.siteFor(r.Host)
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	site.server.HTTPServer.Handler.ServeHTTP(recorder, r)
+
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	siteRequestsCounter.WithLabelValues(site.name, strconv.Itoa(recorder.status)).Inc()
+}
+
+// sitesServeTLS reports whether sites are served over https, which they all are with
+// certInfo, or when any of them has its own certificate. They're all served on the one
+// listener, so without certInfo it's a mistake for only some of them to have their own: the
+// rest would have no certificate to be served with.
+func sitesServeTLS(sites []SiteConfig, certInfo *CertInfo) (bool, error) {
+	if certInfo != nil {
+		return true, nil
+	}
+
+	withCert, withoutCert := 0, []string{}
+	for _, site := range sites {
+		if site.CertPath != "" {
+			withCert++
+		} else {
+			withoutCert = append(withoutCert, site.Hosts[0])
+		}
+	}
+
+	if withCert > 0 && len(withoutCert) > 0 {
+		return false, fmt.Errorf("once one site has its own certificate every site is served over https, and %s have none: "+
+			"give them a cert, or pass --cert or --acme for them", strings.Join(withoutCert, ", "))
+	}
+
+	return withCert > 0, nil
+}
+
+// ListenAndServe serves the sites. With certInfo, or when the sites have their own
+// certificates, it serves https, and each handshake gets the certificate for the site its
+// server name belongs to: the site's own, then one from ACME, then certInfo's, then the
+// default site's. Otherwise it serves http.
+func (v *VirtualHosts) ListenAndServe(certInfo *CertInfo) error {
+	configs := []SiteConfig{}
+	for _, site := range v.sites {
+		configs = append(configs, site.config)
+		site.server.shared().redirects.start(site.server.SiteFiles, site.server.partialDepth())
+	}
+
+	useTLS, err := sitesServeTLS(configs, certInfo)
+	if err != nil {
+		return err
+	}
+
+	for _, site := range v.sites {
+		if site.config.CertPath == "" {
+			continue
+		}
+
+		reloader, err := newCertReloader(site.config.CertPath, site.config.PrivateKeyPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", site.name, err)
+		}
+		site.cert = reloader
+	}
+
+	socketMode := v.defaultSite.server.SocketMode
+
+	if !useTLS {
+		listener, err := listen(v.Address, socketMode)
+		if err != nil {
+			return err
+		}
+
+		return v.HTTPServer.Serve(listener)
+	}
+
+	tlsConfig := &tls.Config{GetCertificate: v.getCertificate}
+	if err := v.defaultSite.server.configureTLS(tlsConfig); err != nil {
+		return err
+	}
+
+	httpAddress := v.defaultSite.server.HTTPAddress
+
+	switch {
+	case certInfo != nil && certInfo.Acme != nil:
+		hosts := []string{}
+		for host, site := range v.hosts {
+			if site.cert == nil {
+				hosts = append(hosts, host)
+			}
+		}
+
+		manager, err := newAcmeManager(*certInfo.Acme, hosts...)
+		if err != nil {
+			return err
+		}
+		v.acmeManager = manager
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+
+		if httpAddress == "" {
+			httpAddress = certInfo.Acme.HTTPAddress
+		}
+
+	case certInfo != nil:
+		reloader, err := newCertReloader(certInfo.CertPath, certInfo.PrivateKeyPath)
+		if err != nil {
+			return err
+		}
+		v.cert = reloader
+	}
+
+	listener, err := listen(v.Address, socketMode)
+	if err != nil {
+		return err
+	}
+
+	for _, reloader := range v.certReloaders() {
+		v.stopReloads = append(v.stopReloads, reloader.reloadOnSIGHUP())
+	}
+
+	if httpAddress != "" {
+		var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v.siteFor(r.Host).server.httpsRedirect()(w, r)
+		})
+		if v.acmeManager != nil {
+			handler = v.acmeManager.HTTPHandler(handler)
+		}
+
+		v.redirectServer = startHTTPServer(httpAddress, handler, socketMode)
+	}
+
+	v.HTTPServer.TLSConfig = tlsConfig
+
+	return v.HTTPServer.ServeTLS(listener, "", "")
+}
+
+// certReloaders are the certificates being served, so they can be reloaded on SIGHUP.
+func (v *VirtualHosts) certReloaders() []*certReloader {
+	reloaders := []*certReloader{}
+
+	if v.cert != nil {
+		reloaders = append(reloaders, v.cert)
+	}
+
+	for _, site := range v.sites {
+		if site.cert != nil {
+			reloaders = append(reloaders, site.cert)
+		}
+	}
+
+	return reloaders
+}
+
+// getCertificate is for tls.Config.GetCertificate. It picks the certificate for the site
+// the handshake's server name belongs to.
+func (v *VirtualHosts) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	site, known := v.hosts[normalizeHost(hello.ServerName)]
+
+	switch {
+	case known && site.cert != nil:
+		return site.cert.GetCertificate(hello)
+	case known && v.acmeManager != nil:
+		return logCertificateErrors(v.acmeManager.GetCertificate)(hello)
+	case v.cert != nil:
+		return v.cert.GetCertificate(hello)
+	case v.defaultSite.cert != nil:
+		return v.defaultSite.cert.GetCertificate(hello)
+	}
+
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// Shutdown stops listening, and stops serving every site once the requests in flight have
+// been answered, or ctx is done.
+func (v *VirtualHosts) Shutdown(ctx context.Context) error {
+	for _, stop := range v.stopReloads {
+		stop()
+	}
+
+	if v.redirectServer != nil {
+		v.redirectServer.Shutdown(ctx)
+	}
+
+	return v.HTTPServer.Shutdown(ctx)
+}
+
+// Close stops serving every site.
+func (v *VirtualHosts) Close() error {
+	for _, stop := range v.stopReloads {
+		stop()
+	}
+
+	if v.redirectServer != nil {
+		v.redirectServer.Close()
+	}
+
+	return v.HTTPServer.Close()
+}
@@ -0,0 +1,104 @@
+package andrew
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	t.Parallel()
+
+	for host, expected := range map[string]string{
+		"Example.COM":         "example.com",
+		"example.com:8443":    "example.com",
+		"example.com.":        "example.com",
+		" example.com ":       "example.com",
+		"[::1]:8080":          "::1",
+		"www.example.com.:80": "www.example.com",
+	} {
+		if got := normalizeHost(host); got != expected {
+			t.Errorf("%q: expected %q, received %q", host, expected, got)
+		}
+	}
+}
+
+func TestVirtualHostsPickEachSitesCertificate(t *testing.T) {
+	t.Parallel()
+
+	catsCert, catsKey := writeTestCertificate(t, t.TempDir(), "cats.test", time.Now().Add(time.Hour))
+	dogsCert, dogsKey := writeTestCertificate(t, t.TempDir(), "dogs.test", time.Now().Add(time.Hour))
+	globalCert, globalKey := writeTestCertificate(t, t.TempDir(), "global.test", time.Now().Add(time.Hour))
+
+	opts, _, err := ParseOptions([]string{}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sites := []SiteConfig{
+		{Hosts: []string{"cats.test", "www.cats.test"}, ContentRoot: t.TempDir(), CertPath: catsCert, PrivateKeyPath: catsKey},
+		{Hosts: []string{"dogs.test"}, ContentRoot: t.TempDir(), CertPath: dogsCert, PrivateKeyPath: dogsKey, Default: true},
+	}
+
+	newVirtualHosts := func(sites []SiteConfig) *VirtualHosts {
+		t.Helper()
+
+		v, err := NewVirtualHosts(SitesConfig{Sites: sites}, "localhost:0", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, site := range v.sites {
+			if site.config.CertPath == "" {
+				continue
+			}
+			if site.cert, err = newCertReloader(site.config.CertPath, site.config.PrivateKeyPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return v
+	}
+
+	servedFor := func(v *VirtualHosts, serverName string) string {
+		t.Helper()
+
+		cert, err := v.getCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cert.Leaf.Subject.CommonName
+	}
+
+	// Without --cert, server names that aren't any site's get the default site's certificate.
+	v := newVirtualHosts(sites)
+	for serverName, expected := range map[string]string{
+		"www.cats.test": "cats.test",
+		"DOGS.test":     "dogs.test",
+		"unknown.test":  "dogs.test",
+		"":              "dogs.test",
+	} {
+		if got := servedFor(v, serverName); got != expected {
+			t.Errorf("%q: expected %s's certificate, received %s's", serverName, expected, got)
+		}
+	}
+
+	// With --cert, sites without a certificate of their own can be served too.
+	opts.CertInfo = &CertInfo{CertPath: globalCert, PrivateKeyPath: globalKey}
+	v = newVirtualHosts(append(sites, SiteConfig{Hosts: []string{"birds.test"}, ContentRoot: t.TempDir()}))
+	if v.cert, err = newCertReloader(globalCert, globalKey); err != nil {
+		t.Fatal(err)
+	}
+
+	for serverName, expected := range map[string]string{
+		"cats.test":    "cats.test",
+		"birds.test":   "global.test",
+		"unknown.test": "global.test",
+	} {
+		if got := servedFor(v, serverName); got != expected {
+			t.Errorf("%q: expected %s's certificate, received %s's", serverName, expected, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package andrew_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playtechnique/andrew"
+)
+
+// writeSites writes each site's files into its own content root, and a sites config
+// listing them, into a temporary directory. It returns the config's path.
+func writeSites(t *testing.T, config string, sites map[string]map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for contentRoot, files := range sites {
+		for name, content := range files {
+			filePath := filepath.Join(dir, contentRoot, name)
+			if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	configPath := filepath.Join(dir, "sites.json")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return configPath
+}
+
+func newTestVirtualHosts(t *testing.T, configPath string) *andrew.VirtualHosts {
+	t.Helper()
+
+	config, err := andrew.LoadSitesConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, _, err := andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sites, err := andrew.NewVirtualHosts(config, freeAddress(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sites.Close() })
+
+	go func() {
+		if err := sites.ListenAndServe(nil); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Log("Server stopped with error:", err)
+		}
+	}()
+
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", sites.Address)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 20 {
+			t.Fatalf("nothing listening on %s", sites.Address)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return sites
+}
+
+func getFromHost(t *testing.T, address string, host string, requestURI string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+requestURI, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+
+	resp, err := noRedirectClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestVirtualHostsServeEachSiteForItsHosts(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSites(t, `{"sites": [
+		{"hosts": ["www.cats.test", "cats.test"], "contentRoot": "cats", "baseUrl": "http://www.cats.test"},
+		{"hosts": ["dogs.test"], "contentRoot": "dogs", "rssTitle": "Woof", "default": true}
+	]}`, map[string]map[string]string{
+		"cats": {"index.html": `<title>cats</title>`, "naps.html": `<title>naps</title>`},
+		"dogs": {"index.html": `<title>dogs</title>`, "walks.html": `<title>walks</title>`},
+	})
+
+	sites := newTestVirtualHosts(t, configPath)
+
+	for host, expected := range map[string]string{
+		"www.cats.test":  "<title>cats</title>",
+		"CATS.test":      "<title>cats</title>",
+		"dogs.test:8080": "<title>dogs</title>",
+		"unknown.test":   "<title>dogs</title>",
+		"www.cats.test.": "<title>cats</title>",
+		sites.Address:    "<title>dogs</title>",
+	} {
+		status, body := getFromHost(t, sites.Address, host, "/")
+		if status != http.StatusOK || body != expected {
+			t.Errorf("%s: expected 200 %q, received %d %q", host, expected, status, body)
+		}
+	}
+
+	// A site's pages only exist on that site.
+	if status, _ := getFromHost(t, sites.Address, "cats.test", "/walks.html"); status != http.StatusNotFound {
+		t.Errorf("expected a 404 for another site's page, received %d", status)
+	}
+
+	_, sitemap := getFromHost(t, sites.Address, "cats.test", "/sitemap.xml")
+	if !strings.Contains(sitemap, "http://www.cats.test/naps.html") || strings.Contains(sitemap, "walks") {
+		t.Errorf("expected the cats sitemap to list only cats pages, received %q", sitemap)
+	}
+
+	_, feed := getFromHost(t, sites.Address, "dogs.test", "/rss.xml")
+	if !strings.Contains(feed, "<title>Woof</title>") || !strings.Contains(feed, "http://dogs.test/walks.html") {
+		t.Errorf("expected the dogs feed to be called Woof and list dogs pages, received %q", feed)
+	}
+
+	if sites.Site("cats.test").BaseUrl != "http://www.cats.test" {
+		t.Errorf("expected cats.test's site to have base url http://www.cats.test, received %q", sites.Site("cats.test").BaseUrl)
+	}
+
+	_, metrics := getFromHost(t, sites.Address, "dogs.test", "/metrics")
+	for _, want := range []string{
+		`andrew_site_requests_total{code="200",site="www.cats.test"}`,
+		`andrew_site_requests_total{code="404",site="www.cats.test"}`,
+		`andrew_site_requests_total{code="200",site="dogs.test"}`,
+		// Both sites have an index.html, and each is counted on its own.
+		`andrew_server_serve_allrequests_200bypath{path="index.html",site="www.cats.test",status="200"}`,
+		`andrew_server_serve_allrequests_200bypath{path="index.html",site="dogs.test",status="200"}`,
+		`andrew_http_request_duration_seconds_count{code="200",handler="page",site="www.cats.test"}`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected /metrics to contain %s", want)
+		}
+	}
+}
+
+func TestNewVirtualHostsNeedsACertificateForEverySiteOnceOneHasOne(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSites(t, `{"sites": [
+		{"hosts": ["cats.test"], "contentRoot": "cats", "cert": "cats.crt", "privateKey": "cats.key"},
+		{"hosts": ["dogs.test"], "contentRoot": "dogs"}
+	]}`, map[string]map[string]string{
+		"cats": {"index.html": `<title>cats</title>`},
+		"dogs": {"index.html": `<title>dogs</title>`},
+	})
+
+	config, err := andrew.LoadSitesConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts, _, err := andrew.ParseOptions([]string{}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = andrew.NewVirtualHosts(config, freeAddress(t), opts)
+	if err == nil || !strings.Contains(err.Error(), "dogs.test") {
+		t.Errorf("expected an error naming dogs.test, which has no certificate, received %v", err)
+	}
+
+	// --cert covers the sites without their own, and every site's address is https.
+	opts.CertInfo = &andrew.CertInfo{CertPath: "testdata/localhost.crt", PrivateKeyPath: "testdata/localhost.key"}
+
+	sites, err := andrew.NewVirtualHosts(config, freeAddress(t), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"cats.test", "dogs.test"} {
+		if baseUrl := sites.Site(host).BaseUrl; baseUrl != "https://"+host {
+			t.Errorf("%s: expected base url https://%s, received %q", host, host, baseUrl)
+		}
+	}
+}
+
+func TestParseOptionsTakesHttpsFromTheSitesCertificates(t *testing.T) {
+	t.Parallel()
+
+	withCerts := writeSites(t, `{"sites": [
+		{"hosts": ["cats.test"], "contentRoot": "cats", "cert": "cats.crt", "privateKey": "cats.key"},
+		{"hosts": ["dogs.test"], "contentRoot": "dogs", "cert": "dogs.crt", "privateKey": "dogs.key"}
+	]}`, nil)
+
+	opts, _, err := andrew.ParseOptions([]string{"--sites", withCerts, "--http-address", ":80",
+		"--client-ca", "testdata/acme-ca.pem", "--client-cert-path", "/drafts/"}, new(bytes.Buffer))
+	if err != nil {
+		t.Fatalf("expected the sites' certificates to be enough for --http-address and --client-cert-path, received %v", err)
+	}
+
+	if !opts.SecurityInfo.Headers {
+		t.Error("expected security headers to be on, as the sites are served over https")
+	}
+
+	withoutCerts := writeSites(t, `{"sites": [{"hosts": ["cats.test"], "contentRoot": "cats"}]}`, nil)
+	if _, _, err := andrew.ParseOptions([]string{"--sites", withoutCerts, "--http-address", ":80"}, new(bytes.Buffer)); err == nil {
+		t.Error("expected --http-address to need https from somewhere")
+	}
+}
+
+func TestLoadSitesConfigRejectsMistakes(t *testing.T) {
+	t.Parallel()
+
+	for config, want := range map[string]string{
+		`{"sites": []}`:                      "has no sites",
+		`{"sites": [{"contentRoot": "a"}]}`:  "has no hosts",
+		`{"sites": [{"hosts": ["a.test"]}]}`: "has no contentRoot",
+		`{"sites": [{"hosts": ["a.test"], "contentRoot": "a"}, {"hosts": ["A.test"], "contentRoot": "b"}]}`:                                   "answered by more than one site",
+		`{"sites": [{"hosts": ["a.test"], "contentRoot": "a", "cert": "a.crt"}]}`:                                                             "needs both cert and privateKey",
+		`{"sites": [{"hosts": ["a.test"], "contentRoot": "a", "default": true}, {"hosts": ["b.test"], "contentRoot": "b", "default": true}]}`: "only one site can be the default",
+		`{"sites": [{"hosts": ["a.test"], "root": "a"}]}`:                                                                                     "unknown field",
+	} {
+		configPath := filepath.Join(t.TempDir(), "sites.json")
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := andrew.LoadSitesConfig(configPath)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error containing %q, received %v", config, want, err)
+		}
+	}
+}
+
+func TestLoadSitesConfigFindsPathsFromTheConfigsDirectory(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSites(t, `{"sites": [{"hosts": ["a.test"], "contentRoot": "a", "cert": "/etc/a.crt", "privateKey": "a.key"}]}`, nil)
+
+	config, err := andrew.LoadSitesConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Dir(configPath)
+	site := config.Sites[0]
+
+	if site.ContentRoot != filepath.Join(dir, "a") || site.CertPath != "/etc/a.crt" || site.PrivateKeyPath != filepath.Join(dir, "a.key") {
+		t.Errorf("expected paths relative to %s, received %+v", dir, site)
+	}
+}
+
+func TestMainCalledWithSitesTakesOnlyAnAddress(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeSites(t, `{"sites": [{"hosts": ["a.test"], "contentRoot": "a"}]}`, map[string]map[string]string{"a": {"index.html": `<title>a</title>`}})
+
+	requireExitWithErrorContaining(t, "only argument", []string{"--sites", configPath, ".", "localhost:0"})
+	requireExitWithErrorContaining(t, "sites config file does not exist", []string{"--sites", "testdata/missing.json"})
+}